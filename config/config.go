@@ -3,7 +3,12 @@ package config
 import (
 	"fmt"
 	"os"
+	"os/user"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,8 +16,156 @@ import (
 const (
 	DefaultCheckIntervalSeconds = 5 * 60
 	DefaultComposeFile = "docker-compose.yml"
+	DefaultTestTimeoutSeconds = 10 * 60
+	DefaultHookTimeoutSeconds = 5 * 60
+	DefaultTokenUsername = "x-access-token"
+	DefaultKillGracePeriodSeconds = 10
+	DefaultFetchTimeoutSeconds = 2 * 60
+	DefaultPullTimeoutSeconds = 10 * 60
+	DefaultBuildTimeoutSeconds = 30 * 60
+	DefaultDeployTimeoutSeconds = 10 * 60
+	DefaultHealthCheckSeconds = 30
+	DefaultRetryAttempts = 3
+	DefaultRetryInitialDelaySeconds = 2
+	DefaultRetryMaxDelaySeconds = 30
 )
 
+// Hook failure policies.
+const (
+	HookOnFailureAbort = "abort"
+	HookOnFailureWarn  = "warn"
+)
+
+// TestConfig describes the command that must succeed before a new commit is deployed.
+// When Service is set the command runs in a one-off container of that compose service,
+// otherwise it runs on the host in the clone directory.
+type TestConfig struct {
+	Command []string `yaml:"command"`
+	Service string `yaml:"service"`
+	TimeoutSeconds int `yaml:"timeoutSeconds"`
+}
+
+// Tracking modes select what rivet deploys: the head of a branch or the highest matching release tag.
+const (
+	TrackBranch = "branch"
+	TrackTag    = "tag"
+)
+
+// Git backends for clone, fetch, rev-parse, ancestry checks, fast-forward and checkout: the git
+// binary, or an in-process pure-Go implementation. The go backend still needs the git binary for
+// everything else, e.g. status, log, diff, tags, ls-remote, remotes, signature verification,
+// submodules, LFS and sparse checkout.
+const (
+	GitBackendExec = "exec"
+	GitBackendGo   = "go"
+)
+
+// Policies for a local checkout that is not an ancestor of the remote branch, e.g. after a force-push.
+const (
+	DivergenceRefuse  = "refuse"
+	DivergenceReset   = "reset"
+	DivergenceReclone = "reclone"
+)
+
+// Policies for uncommitted changes or untracked files found in the clone before pulling.
+const (
+	DirtyBlock   = "block"
+	DirtyStash   = "stash"
+	DirtyDiscard = "discard"
+)
+
+// Policies for an existing clone whose origin URL or branch no longer matches the configuration.
+const (
+	MismatchUpdate  = "update"
+	MismatchReclone = "reclone"
+	MismatchRefuse  = "refuse"
+)
+
+// Signature verification scopes: only the commit being deployed, or every new commit.
+const (
+	SignatureScopeHead = "head"
+	SignatureScopeAll  = "all"
+)
+
+// SignatureConfig requires commits to carry a valid signature from a trusted key before they are
+// deployed. SSH signatures are checked against AllowedSignersFile (ssh-keygen ALLOWED SIGNERS format)
+// and GPG signatures against the fully trusted keys in the GnuPG home directory GPGHome.
+type SignatureConfig struct {
+	Scope string `yaml:"scope"`
+	AllowedSignersFile string `yaml:"allowedSignersFile"`
+	GPGHome string `yaml:"gpgHome"`
+}
+
+// RetryConfig controls how git and docker commands that fail transiently, e.g. on a network error,
+// an HTTP 5xx from a registry or a held .git/index.lock, are retried with exponential backoff.
+// Tests and hooks are never retried.
+type RetryConfig struct {
+	Attempts int `yaml:"attempts"` // tries in total; 1 disables retries
+	InitialDelaySeconds int `yaml:"initialDelaySeconds"`
+	MaxDelaySeconds int `yaml:"maxDelaySeconds"`
+}
+
+// RunAsConfig names the unprivileged user that git, a host test command and host hooks run as.
+// Either User, which is looked up to fill in the rest, or UID and GID must be set. Docker commands,
+// including tests and hooks run in a compose service, keep rivet's privileges: a test command that
+// calls docker itself needs Service instead.
+type RunAsConfig struct {
+	User string `yaml:"user"`
+	UID *int `yaml:"uid"`
+	GID *int `yaml:"gid"`
+	Home string `yaml:"home"` // HOME for the user's commands; defaults to the user's home directory
+}
+
+// AuthConfig selects the credentials git uses for a repository instead of those of the rivet process.
+// SSH remotes use SSHKeyFile and optionally KnownHostsFile; HTTPS remotes use a token read from
+// TokenFile or the environment variable named by TokenEnv.
+type AuthConfig struct {
+	SSHKeyFile string `yaml:"sshKeyFile"`
+	KnownHostsFile string `yaml:"knownHostsFile"`
+	Username string `yaml:"username"`
+	TokenFile string `yaml:"tokenFile"`
+	TokenEnv string `yaml:"tokenEnv"`
+}
+
+// CloneConfig reduces how much of a large repository is cloned. Depth creates a shallow,
+// single-branch clone; Filter requests a partial clone (e.g. "blob:none"); SparsePaths limits
+// the checkout to the listed directories.
+type CloneConfig struct {
+	Depth int `yaml:"depth"`
+	Filter string `yaml:"filter"`
+	SparsePaths []string `yaml:"sparsePaths"`
+}
+
+// HookConfig describes a command run before or after the new containers take traffic.
+// When Service is set the command runs in a one-off container of that compose service,
+// otherwise it runs on the host in the clone directory.
+type HookConfig struct {
+	Name string `yaml:"name"`
+	Command []string `yaml:"command"`
+	Service string `yaml:"service"`
+	OnFailure string `yaml:"onFailure"`
+	TimeoutSeconds int `yaml:"timeoutSeconds"`
+}
+
+// TimeoutsConfig bounds how long each stage of an update may run, in seconds. The test stage and
+// individual hooks have their own timeoutSeconds; Hooks is the default for hooks that do not.
+type TimeoutsConfig struct {
+	Fetch int `yaml:"fetch"` // update check: mirror sync, ls-remote and fetch
+	Pull int `yaml:"pull"` // initial clone, pull, submodule and LFS updates
+	Build int `yaml:"build"`
+	Deploy int `yaml:"deploy"` // includes the health check
+	HealthCheck int `yaml:"healthCheck"` // how long new containers are given to become healthy
+	Hooks int `yaml:"hooks"`
+}
+
+// PathFilterConfig limits deployments to updates that touch matching files.
+// Patterns are matched against slash-separated paths relative to the repository root;
+// "*" matches within a path segment and "**" matches any number of segments.
+type PathFilterConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
 type RepositoryConfig struct {
 	BasePath string `yaml:"basePath"`
 	GitURL 	 string `yaml:"gitUrl"`
@@ -21,9 +174,45 @@ type RepositoryConfig struct {
 	ServiceName string `yaml:"serviceName"`
 	ComposeFile string `yaml:"composeFile"`
 	CheckIntervalSeconds int `yaml:"checkIntervalSeconds"`
+	Test *TestConfig `yaml:"test"`
+	PreDeploy []HookConfig `yaml:"preDeploy"`
+	PostDeploy []HookConfig `yaml:"postDeploy"`
+	Paths *PathFilterConfig `yaml:"paths"`
+	OnDivergence string `yaml:"onDivergence"`
+	OnDirty string `yaml:"onDirty"`
+	OnConfigMismatch string `yaml:"onConfigMismatch"`
+	Track string `yaml:"track"`
+	TagPattern string `yaml:"tagPattern"`
+	TagRegex string `yaml:"tagRegex"`
+	AllowDowngrade bool `yaml:"allowDowngrade"`
+	Pin string `yaml:"pin"`
+	VerifySignatures *SignatureConfig `yaml:"verifySignatures"`
+	Auth *AuthConfig `yaml:"auth"`
+	Clone *CloneConfig `yaml:"clone"`
+	Submodules bool `yaml:"submodules"`
+	LFS bool `yaml:"lfs"`
+	GitBackend string `yaml:"gitBackend"`
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+	RunAs *RunAsConfig `yaml:"runAs"`
+	// Env is set for every command run for this repository, e.g. COMPOSE_PROJECT_NAME or DOCKER_HOST.
+	Env map[string]string `yaml:"env"`
 }
 
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 type AppConfig struct {
+	// MirrorDir enables a shared bare mirror per unique gitUrl, stored in this directory.
+	MirrorDir string `yaml:"mirrorDir"`
+	// KillGracePeriodSeconds is how long a cancelled command's process group has to exit
+	// after SIGTERM before it is sent SIGKILL.
+	KillGracePeriodSeconds int `yaml:"killGracePeriodSeconds"`
+	// InheritEnv lists the variables of rivet's own environment passed on to git, docker and
+	// hooks. When empty, the executor's default allow-list is used.
+	InheritEnv []string `yaml:"inheritEnv"`
+	// SessionDir enables recording every command of each run that deploys or fails to a
+	// session file in this directory, for replaying with -replay.
+	SessionDir string `yaml:"sessionDir"`
+	Retry RetryConfig `yaml:"retry"`
 	Repositories []RepositoryConfig `yaml:"repositories"`
 }
 
@@ -54,6 +243,21 @@ func LoadConfig(filePath string) (*AppConfig, error) {
 	}
 
 	// Validate and apply defaults
+	if cfg.KillGracePeriodSeconds < 0 {
+		return nil, fmt.Errorf("'killGracePeriodSeconds' must not be negative")
+	}
+	if cfg.KillGracePeriodSeconds == 0 {
+		cfg.KillGracePeriodSeconds = DefaultKillGracePeriodSeconds
+	}
+	if err := applyRetryDefaults(&cfg.Retry); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.InheritEnv {
+		if !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("'inheritEnv' has invalid variable name '%s'", name)
+		}
+	}
+
 	for i := range cfg.Repositories {
 		repo := &cfg.Repositories[i] // Get a pointer to modify the struct in the slice
 
@@ -66,9 +270,30 @@ func LoadConfig(filePath string) (*AppConfig, error) {
 		if repo.CloneDirName == "" {
 			return nil, fmt.Errorf("repository config for gitUrl '%s' missing required 'cloneDirName'", repo.GitURL)
 		}
-		if repo.Branch == "" {
+		switch repo.Track {
+		case "":
+			repo.Track = TrackBranch
+		case TrackBranch, TrackTag:
+		default:
+			return nil, fmt.Errorf("repository config for '%s/%s' has invalid track '%s' (expected '%s' or '%s')", repo.BasePath, repo.CloneDirName, repo.Track, TrackBranch, TrackTag)
+		}
+		if repo.Branch == "" && repo.Track == TrackBranch {
 			return nil, fmt.Errorf("repository config for '%s/%s' missing required 'branch'", repo.BasePath, repo.CloneDirName)
 		}
+		if repo.Track == TrackTag {
+			if repo.TagPattern != "" && repo.TagRegex != "" {
+				return nil, fmt.Errorf("repository config for '%s/%s' sets both 'tagPattern' and 'tagRegex'", repo.BasePath, repo.CloneDirName)
+			}
+			if repo.TagPattern == "" && repo.TagRegex == "" {
+				repo.TagPattern = "*"
+			}
+			if _, err := path.Match(repo.TagPattern, ""); err != nil {
+				return nil, fmt.Errorf("repository config for '%s/%s' has invalid tagPattern '%s': %w", repo.BasePath, repo.CloneDirName, repo.TagPattern, err)
+			}
+			if _, err := regexp.Compile(repo.TagRegex); err != nil {
+				return nil, fmt.Errorf("repository config for '%s/%s' has invalid tagRegex '%s': %w", repo.BasePath, repo.CloneDirName, repo.TagRegex, err)
+			}
+		}
 		if repo.ServiceName == "" {
 			return nil, fmt.Errorf("repository config for '%s/%s' missing required 'serviceName'", repo.BasePath, repo.CloneDirName)
 		}
@@ -79,7 +304,208 @@ func LoadConfig(filePath string) (*AppConfig, error) {
 		if repo.CheckIntervalSeconds <= 0 {
 			repo.CheckIntervalSeconds = DefaultCheckIntervalSeconds
 		}
+		switch repo.OnDivergence {
+		case "":
+			repo.OnDivergence = DivergenceRefuse
+		case DivergenceRefuse, DivergenceReset, DivergenceReclone:
+		default:
+			return nil, fmt.Errorf("repository config for '%s/%s' has invalid onDivergence '%s' (expected '%s', '%s' or '%s')", repo.BasePath, repo.CloneDirName, repo.OnDivergence, DivergenceRefuse, DivergenceReset, DivergenceReclone)
+		}
+		switch repo.OnDirty {
+		case "":
+			repo.OnDirty = DirtyBlock
+		case DirtyBlock, DirtyStash, DirtyDiscard:
+		default:
+			return nil, fmt.Errorf("repository config for '%s/%s' has invalid onDirty '%s' (expected '%s', '%s' or '%s')", repo.BasePath, repo.CloneDirName, repo.OnDirty, DirtyBlock, DirtyStash, DirtyDiscard)
+		}
+		switch repo.OnConfigMismatch {
+		case "":
+			repo.OnConfigMismatch = MismatchUpdate
+		case MismatchUpdate, MismatchReclone, MismatchRefuse:
+		default:
+			return nil, fmt.Errorf("repository config for '%s/%s' has invalid onConfigMismatch '%s' (expected '%s', '%s' or '%s')", repo.BasePath, repo.CloneDirName, repo.OnConfigMismatch, MismatchUpdate, MismatchReclone, MismatchRefuse)
+		}
+		if repo.Test != nil {
+			if len(repo.Test.Command) == 0 {
+				return nil, fmt.Errorf("repository config for '%s/%s' has a 'test' section without a 'command'", repo.BasePath, repo.CloneDirName)
+			}
+			if repo.Test.TimeoutSeconds <= 0 {
+				repo.Test.TimeoutSeconds = DefaultTestTimeoutSeconds
+			}
+		}
+		if repo.Paths != nil {
+			for _, pattern := range append(append([]string{}, repo.Paths.Include...), repo.Paths.Exclude...) {
+				if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+					return nil, fmt.Errorf("repository config for '%s/%s' has invalid path pattern '%s': %w", repo.BasePath, repo.CloneDirName, pattern, err)
+				}
+			}
+		}
+		if sig := repo.VerifySignatures; sig != nil {
+			if sig.AllowedSignersFile == "" && sig.GPGHome == "" {
+				return nil, fmt.Errorf("repository config for '%s/%s' has 'verifySignatures' without 'allowedSignersFile' or 'gpgHome'", repo.BasePath, repo.CloneDirName)
+			}
+			switch sig.Scope {
+			case "":
+				sig.Scope = SignatureScopeHead
+			case SignatureScopeHead, SignatureScopeAll:
+			default:
+				return nil, fmt.Errorf("repository config for '%s/%s' has invalid verifySignatures scope '%s' (expected '%s' or '%s')", repo.BasePath, repo.CloneDirName, sig.Scope, SignatureScopeHead, SignatureScopeAll)
+			}
+		}
+		if auth := repo.Auth; auth != nil {
+			if auth.TokenFile != "" && auth.TokenEnv != "" {
+				return nil, fmt.Errorf("repository config for '%s/%s' sets both 'tokenFile' and 'tokenEnv'", repo.BasePath, repo.CloneDirName)
+			}
+			if auth.TokenEnv != "" && !envNamePattern.MatchString(auth.TokenEnv) {
+				return nil, fmt.Errorf("repository config for '%s/%s' has invalid tokenEnv '%s'", repo.BasePath, repo.CloneDirName, auth.TokenEnv)
+			}
+			if auth.Username == "" {
+				auth.Username = DefaultTokenUsername
+			}
+		}
+		if repo.Clone != nil && repo.Clone.Depth < 0 {
+			return nil, fmt.Errorf("repository config for '%s/%s' has negative clone depth %d", repo.BasePath, repo.CloneDirName, repo.Clone.Depth)
+		}
+		switch repo.GitBackend {
+		case "":
+			repo.GitBackend = GitBackendExec
+		case GitBackendExec:
+		case GitBackendGo:
+			if repo.Clone != nil && (repo.Clone.Filter != "" || len(repo.Clone.SparsePaths) > 0) {
+				return nil, fmt.Errorf("repository config for '%s/%s' uses gitBackend '%s', which does not support clone 'filter' or 'sparsePaths'", repo.BasePath, repo.CloneDirName, GitBackendGo)
+			}
+		default:
+			return nil, fmt.Errorf("repository config for '%s/%s' has invalid gitBackend '%s' (expected '%s' or '%s')", repo.BasePath, repo.CloneDirName, repo.GitBackend, GitBackendExec, GitBackendGo)
+		}
+		for name := range repo.Env {
+			if !envNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("repository config for '%s/%s' has invalid env variable name '%s'", repo.BasePath, repo.CloneDirName, name)
+			}
+		}
+		if repo.RunAs != nil {
+			if repo.GitBackend == GitBackendGo {
+				return nil, fmt.Errorf("repository config for '%s/%s' uses gitBackend '%s', which does part of its work in-process and cannot use 'runAs'", repo.BasePath, repo.CloneDirName, GitBackendGo)
+			}
+			if err := resolveRunAs(repo); err != nil {
+				return nil, err
+			}
+		}
+		if err := applyTimeoutDefaults(repo); err != nil {
+			return nil, err
+		}
+		if err := applyHookDefaults(repo, "preDeploy", repo.PreDeploy); err != nil {
+			return nil, err
+		}
+		if err := applyHookDefaults(repo, "postDeploy", repo.PostDeploy); err != nil {
+			return nil, err
+		}
 	}
 
 	return &cfg, nil
-}
\ No newline at end of file
+}
+
+// resolveRunAs fills in the uid, gid and home directory of a runAs user given by name.
+func resolveRunAs(repo *RepositoryConfig) error {
+	runAs := repo.RunAs
+	if runAs.User != "" {
+		u, err := user.Lookup(runAs.User)
+		if err != nil {
+			return fmt.Errorf("repository config for '%s/%s' has runAs user '%s' that cannot be resolved: %w", repo.BasePath, repo.CloneDirName, runAs.User, err)
+		}
+		uid, uidErr := strconv.Atoi(u.Uid)
+		gid, gidErr := strconv.Atoi(u.Gid)
+		if uidErr != nil || gidErr != nil {
+			return fmt.Errorf("repository config for '%s/%s' has runAs user '%s' without numeric ids", repo.BasePath, repo.CloneDirName, runAs.User)
+		}
+		if runAs.UID == nil {
+			runAs.UID = &uid
+		}
+		if runAs.GID == nil {
+			runAs.GID = &gid
+		}
+		if runAs.Home == "" {
+			runAs.Home = u.HomeDir
+		}
+	}
+	if runAs.UID == nil || runAs.GID == nil {
+		return fmt.Errorf("repository config for '%s/%s' has a 'runAs' section without 'user' or both 'uid' and 'gid'", repo.BasePath, repo.CloneDirName)
+	}
+	if *runAs.UID < 0 || *runAs.GID < 0 {
+		return fmt.Errorf("repository config for '%s/%s' has negative runAs ids", repo.BasePath, repo.CloneDirName)
+	}
+	return nil
+}
+
+func applyTimeoutDefaults(repo *RepositoryConfig) error {
+	timeouts := &repo.Timeouts
+	for _, t := range []struct {
+		name  string
+		value *int
+		def   int
+	}{
+		{"fetch", &timeouts.Fetch, DefaultFetchTimeoutSeconds},
+		{"pull", &timeouts.Pull, DefaultPullTimeoutSeconds},
+		{"build", &timeouts.Build, DefaultBuildTimeoutSeconds},
+		{"deploy", &timeouts.Deploy, DefaultDeployTimeoutSeconds},
+		{"healthCheck", &timeouts.HealthCheck, DefaultHealthCheckSeconds},
+		{"hooks", &timeouts.Hooks, DefaultHookTimeoutSeconds},
+	} {
+		if *t.value < 0 {
+			return fmt.Errorf("repository config for '%s/%s' has negative %s timeout %d", repo.BasePath, repo.CloneDirName, t.name, *t.value)
+		}
+		if *t.value == 0 {
+			*t.value = t.def
+		}
+	}
+	if timeouts.HealthCheck >= timeouts.Deploy {
+		return fmt.Errorf("repository config for '%s/%s' has a healthCheck timeout (%ds) that does not fit in the deploy timeout (%ds)", repo.BasePath, repo.CloneDirName, timeouts.HealthCheck, timeouts.Deploy)
+	}
+	return nil
+}
+
+func applyRetryDefaults(retry *RetryConfig) error {
+	for _, r := range []struct {
+		name  string
+		value *int
+		def   int
+	}{
+		{"attempts", &retry.Attempts, DefaultRetryAttempts},
+		{"initialDelaySeconds", &retry.InitialDelaySeconds, DefaultRetryInitialDelaySeconds},
+		{"maxDelaySeconds", &retry.MaxDelaySeconds, DefaultRetryMaxDelaySeconds},
+	} {
+		if *r.value < 0 {
+			return fmt.Errorf("'retry.%s' must not be negative", r.name)
+		}
+		if *r.value == 0 {
+			*r.value = r.def
+		}
+	}
+	if retry.MaxDelaySeconds < retry.InitialDelaySeconds {
+		return fmt.Errorf("'retry.maxDelaySeconds' (%d) must not be less than 'retry.initialDelaySeconds' (%d)", retry.MaxDelaySeconds, retry.InitialDelaySeconds)
+	}
+	return nil
+}
+
+// applyHookDefaults validates a hook list and fills in names, failure policies and timeouts.
+func applyHookDefaults(repo *RepositoryConfig, section string, hooks []HookConfig) error {
+	for i := range hooks {
+		hook := &hooks[i]
+		if len(hook.Command) == 0 {
+			return fmt.Errorf("repository config for '%s/%s' has a %s hook at index %d without a 'command'", repo.BasePath, repo.CloneDirName, section, i)
+		}
+		if hook.Name == "" {
+			hook.Name = strings.Join(hook.Command, " ")
+		}
+		switch hook.OnFailure {
+		case "":
+			hook.OnFailure = HookOnFailureAbort
+		case HookOnFailureAbort, HookOnFailureWarn:
+		default:
+			return fmt.Errorf("repository config for '%s/%s' has %s hook '%s' with invalid onFailure '%s' (expected '%s' or '%s')", repo.BasePath, repo.CloneDirName, section, hook.Name, hook.OnFailure, HookOnFailureAbort, HookOnFailureWarn)
+		}
+		if hook.TimeoutSeconds <= 0 {
+			hook.TimeoutSeconds = repo.Timeouts.Hooks
+		}
+	}
+	return nil
+}
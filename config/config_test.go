@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,4 +16,224 @@ func TestLoadConfigEmptyPath(t *testing.T) {
 	if err == nil {
 		t.Errorf("error must not be nil for empty path")
 	}
-}
\ No newline at end of file
+}
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rivet.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigTestStageDefaults(t *testing.T) {
+	path := writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    test:
+      command: ["docker", "compose", "run", "--rm", "tests"]
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	test := cfg.Repositories[0].Test
+	if test == nil {
+		t.Fatalf("test stage must be loaded")
+	}
+	if test.TimeoutSeconds != DefaultTestTimeoutSeconds {
+		t.Errorf("expected default timeout %d, got %d", DefaultTestTimeoutSeconds, test.TimeoutSeconds)
+	}
+}
+
+func TestLoadConfigTestStageRequiresCommand(t *testing.T) {
+	path := writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    test:
+      timeoutSeconds: 60
+`)
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("error must not be nil for a test stage without a command")
+	}
+}
+
+func TestLoadConfigHookDefaults(t *testing.T) {
+	path := writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    preDeploy:
+      - command: ["./migrate.sh"]
+        service: web
+    postDeploy:
+      - name: smoke
+        command: ["curl", "-f", "http://localhost/health"]
+        onFailure: warn
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pre := cfg.Repositories[0].PreDeploy[0]
+	if pre.Name != "./migrate.sh" || pre.OnFailure != HookOnFailureAbort || pre.TimeoutSeconds != DefaultHookTimeoutSeconds {
+		t.Errorf("unexpected preDeploy defaults: %+v", pre)
+	}
+	if post := cfg.Repositories[0].PostDeploy[0]; post.OnFailure != HookOnFailureWarn {
+		t.Errorf("expected onFailure %q, got %q", HookOnFailureWarn, post.OnFailure)
+	}
+}
+
+func TestLoadConfigHookInvalidOnFailure(t *testing.T) {
+	path := writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    preDeploy:
+      - command: ["./migrate.sh"]
+        onFailure: ignore
+`)
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("error must not be nil for an invalid onFailure policy")
+	}
+}
+
+func TestLoadConfigKillGracePeriod(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "repositories: []\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.KillGracePeriodSeconds != DefaultKillGracePeriodSeconds {
+		t.Errorf("killGracePeriodSeconds = %d, want default %d", cfg.KillGracePeriodSeconds, DefaultKillGracePeriodSeconds)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "killGracePeriodSeconds: -1\n")); err == nil {
+		t.Error("expected an error for a negative kill grace period")
+	}
+}
+
+func TestLoadConfigRetry(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "retry:\n  attempts: 1\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := RetryConfig{Attempts: 1, InitialDelaySeconds: DefaultRetryInitialDelaySeconds, MaxDelaySeconds: DefaultRetryMaxDelaySeconds}
+	if cfg.Retry != want {
+		t.Errorf("retry = %+v, want %+v", cfg.Retry, want)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "retry:\n  initialDelaySeconds: 60\n")); err == nil {
+		t.Error("expected an error for an initial delay above the default max delay")
+	}
+}
+
+func TestLoadConfigInvalidEnvName(t *testing.T) {
+	path := writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    env:
+      COMPOSE_PROJECT_NAME: app
+      "NOT-VALID": x
+`)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for an invalid env variable name")
+	}
+}
+
+func TestLoadConfigTimeouts(t *testing.T) {
+	path := writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    timeouts:
+      build: 60
+      hooks: 20
+    postDeploy:
+      - command: ["./notify.sh"]
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	timeouts := cfg.Repositories[0].Timeouts
+	if timeouts.Build != 60 || timeouts.Fetch != DefaultFetchTimeoutSeconds || timeouts.HealthCheck != DefaultHealthCheckSeconds {
+		t.Errorf("unexpected timeouts %+v", timeouts)
+	}
+	if got := cfg.Repositories[0].PostDeploy[0].TimeoutSeconds; got != 20 {
+		t.Errorf("hook timeout = %d, want the repository's hooks timeout 20", got)
+	}
+
+	path = writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    timeouts:
+      deploy: 20
+      healthCheck: 30
+`)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for a health check longer than the deploy timeout")
+	}
+}
+
+func TestLoadConfigRunAs(t *testing.T) {
+	path := writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    runAs:
+      uid: 1000
+`)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for runAs without a gid")
+	}
+
+	path = writeConfig(t, `
+repositories:
+  - basePath: /srv
+    gitUrl: https://example.com/app.git
+    cloneDirName: app
+    branch: main
+    serviceName: web
+    runAs:
+      uid: 1000
+      gid: 1000
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if runAs := cfg.Repositories[0].RunAs; *runAs.UID != 1000 || *runAs.GID != 1000 {
+		t.Errorf("unexpected runAs %+v", runAs)
+	}
+}
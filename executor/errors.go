@@ -0,0 +1,92 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Result describes a finished command. For streamed commands Stdout and Stderr hold only the
+// retained tail of each stream.
+type Result struct {
+	Command
+	Duration time.Duration
+	ExitCode int // -1 when the command did not run to completion
+	Stdout   string
+	Stderr   string
+	Signal   string // signal that terminated the process, if any
+	TimedOut bool
+}
+
+// NotFoundError reports that the program could not be found or is not executable.
+type NotFoundError struct {
+	Name string
+	Err  error
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("command '%s' not found: %v", e.Name, e.Err)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// ExitError reports a command that ran to completion with a non-zero exit code.
+type ExitError struct {
+	Result *Result
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command '%s' exited with code %d%s", e.Result.Command, e.Result.ExitCode, stderrSummary(e.Result.Stderr))
+}
+
+// TimeoutError reports a command that was killed because its context's deadline passed.
+type TimeoutError struct {
+	Result *Result
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("command '%s' timed out after %s%s", e.Result.Command, e.Result.Duration.Round(time.Millisecond), signalSummary(e.Result.Signal))
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// CancelledError reports a command that was killed because its context was cancelled.
+type CancelledError struct {
+	Result *Result
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("command '%s' cancelled%s", e.Result.Command, signalSummary(e.Result.Signal))
+}
+
+func (e *CancelledError) Unwrap() error {
+	return context.Canceled
+}
+
+// stderrSummary returns the last non-empty line of stderr, which is usually the actual error.
+func stderrSummary(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return ": " + last
+	}
+	return ""
+}
+
+func signalSummary(signal string) string {
+	if signal == "" {
+		return ""
+	}
+	return " (" + signal + ")"
+}
+
+// IsExitCode reports whether err is an *ExitError for a command that exited with code.
+func IsExitCode(err error, code int) bool {
+	var exitErr *ExitError
+	return errors.As(err, &exitErr) && exitErr.Result.ExitCode == code
+}
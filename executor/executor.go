@@ -3,63 +3,161 @@ package executor
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"os/exec"
 	"strings"
 	"syscall"
+	"time"
 )
 
+// Command describes a single invocation of an external program.
+type Command struct {
+	Name string
+	Args []string
+	Dir  string // working directory; the current directory when empty
+	// Env holds KEY=VALUE pairs set on top of the variables inherited from rivet's environment.
+	Env []string
+	// Credential runs the command as another user; nil keeps rivet's own privileges.
+	Credential *Credential
+	// Retryable marks the command as safe to run again after a transient failure; see Retrier.
+	// User-supplied commands such as tests and hooks are never marked.
+	Retryable bool
+}
+
+// Credential identifies the user and primary group a command runs as. Supplementary groups
+// are dropped.
+type Credential struct {
+	UID uint32
+	GID uint32
+}
+
+// String renders the command line for logs and error messages.
+func (c Command) String() string {
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
 // CommandExecutor defines an interface for running external commands.
+// Execute always returns a Result, even when err is non-nil. A command that ran and exited
+// non-zero yields an *ExitError; see errors.go for the other error types.
 type CommandExecutor interface {
-	Execute(ctx context.Context, workingDir string, command string, args ...string) (stdout string, stderr string, exitCode int, err error)
+	Execute(ctx context.Context, cmd Command) (*Result, error)
 }
 
 // OSCommandExecutor is the concrete implementation that uses os/exec.
-type OSCommandExecutor struct{}
+type OSCommandExecutor struct {
+	// TailBytes bounds the output ExecuteStream retains per stream; zero means DefaultTailBytes.
+	TailBytes int
+	// InheritEnv names the variables of rivet's own environment passed to commands; nil means
+	// DefaultInheritEnv. Everything else, including unrelated secrets, is withheld.
+	InheritEnv []string
+	// KillGracePeriod is how long a cancelled command's process group has between SIGTERM and
+	// SIGKILL; zero means DefaultKillGracePeriod.
+	KillGracePeriod time.Duration
+}
+
+// DefaultInheritEnv is the allow-list used when OSCommandExecutor.InheritEnv is nil: what git,
+// ssh and docker need to find their binaries, configuration and agents, and to reach remotes and
+// registries through a proxy. Both spellings of the proxy variables are in use.
+var DefaultInheritEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "TZ", "TMPDIR", "SSH_AUTH_SOCK",
+	"DOCKER_HOST", "DOCKER_CONTEXT", "DOCKER_CONFIG", "DOCKER_CERT_PATH", "DOCKER_TLS_VERIFY",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "ALL_PROXY", "http_proxy", "https_proxy", "no_proxy", "all_proxy",
+}
+
+// DefaultKillGracePeriod is used when OSCommandExecutor.KillGracePeriod is zero.
+const DefaultKillGracePeriod = 10 * time.Second
 
 // NewOSCommandExecutor creates a new instance of OSCommandExecutor.
 func NewOSCommandExecutor() *OSCommandExecutor {
 	return &OSCommandExecutor{}
 }
 
-// Execute runs the given command with arguments in the specified working directory.
-// It returns the standard output, standard error, exit code, and any Go error encountered.
-func (e *OSCommandExecutor) Execute(ctx context.Context, workingDir string, command string, args ...string) (string, string, int, error) {
-	cmd := exec.CommandContext(ctx, command, args...)
-	if workingDir != "" {
-		cmd.Dir = workingDir
+// Execute runs the command, capturing its full output.
+func (e *OSCommandExecutor) Execute(ctx context.Context, command Command) (*Result, error) {
+	cmd, err := e.newCommand(ctx, command)
+	if err != nil {
+		return &Result{Command: command, ExitCode: -1}, err
 	}
 
 	var outBuf, errBuf bytes.Buffer
 	cmd.Stdout = &outBuf
 	cmd.Stderr = &errBuf
 
-	err := cmd.Run() // Run waits for the command to complete.
+	started := time.Now()
+	err = cmd.Run() // Run waits for the command to complete.
 
-	stdout := outBuf.String()
-	stderr := errBuf.String()
-	exitCode := 0
+	return finish(ctx, cmd, command, time.Since(started), err, outBuf.String(), errBuf.String())
+}
 
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			// Command started and exited with non-zero status
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				exitCode = status.ExitStatus()
-			} else {
-				exitCode = -1 // Could not determine exit code
-			}
-			// Return the Go error as well, which includes stderr if captured by ExitError
-			return stdout, stderr, exitCode, fmt.Errorf("command '%s %s' failed with exit code %d: %w. Stderr: %s", command, strings.Join(args, " "), exitCode, err, stderr)
+// newCommand prepares a command that runs in its own process group, as the requested user.
+func (e *OSCommandExecutor) newCommand(ctx context.Context, command Command) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, command.Name, command.Args...)
+	if command.Dir != "" {
+		cmd.Dir = command.Dir
+	}
+	cmd.Env = e.environment(command.Env)
+	grace := e.KillGracePeriod
+	if grace <= 0 {
+		grace = DefaultKillGracePeriod
+	}
+	useProcessGroup(cmd, grace)
+	if command.Credential != nil {
+		if err := useCredential(cmd, command.Credential); err != nil {
+			return nil, fmt.Errorf("failed to run command '%s': %w", command, err)
 		}
-		// Other errors (e.g., command not found, context cancelled before start)
-		exitCode = -1 // Indicate a failure before or during execution not related to command's own exit status
-		return stdout, stderr, exitCode, fmt.Errorf("failed to run command '%s %s': %w. Stderr: %s", command, strings.Join(args, " "), err, stderr)
 	}
+	return cmd, nil
+}
 
-	// Success
-	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
-		exitCode = status.ExitStatus()
+// environment builds a command's environment from the allow-listed parent variables followed by
+// the command's own, which therefore take precedence.
+func (e *OSCommandExecutor) environment(extra []string) []string {
+	names := e.InheritEnv
+	if names == nil {
+		names = DefaultInheritEnv
+	}
+	env := make([]string, 0, len(names)+len(extra))
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
 	}
+	return append(env, extra...)
+}
 
-	return stdout, stderr, exitCode, nil
-}
\ No newline at end of file
+// finish builds the Result of a finished command and classifies its error.
+func finish(ctx context.Context, cmd *exec.Cmd, command Command, duration time.Duration, err error, stdout, stderr string) (*Result, error) {
+	result := &Result{Command: command, Duration: duration, ExitCode: -1, Stdout: stdout, Stderr: stderr}
+	if cmd.ProcessState != nil {
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = status.ExitStatus()
+			if status.Signaled() {
+				result.Signal = status.Signal().String()
+			}
+		}
+	}
+	if err == nil {
+		return result, nil
+	}
+
+	// Killed by us because the context ended: report that rather than the signal's exit status.
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		result.ExitCode = -1
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			result.TimedOut = true
+			return result, &TimeoutError{Result: result}
+		}
+		return result, &CancelledError{Result: result}
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return result, &ExitError{Result: result}
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return result, &NotFoundError{Name: command.Name, Err: err}
+	}
+	return result, fmt.Errorf("failed to run command '%s': %w", command, err)
+}
@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExecuteClassifiesErrors(t *testing.T) {
+	e := NewOSCommandExecutor()
+
+	result, err := e.Execute(context.Background(), Command{Name: "sh", Args: []string{"-c", "echo out; echo 'fatal: bad' >&2; exit 2"}})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || !IsExitCode(err, 2) {
+		t.Fatalf("expected an ExitError with code 2, got %v", err)
+	}
+	if result.Stdout != "out\n" || result.Duration <= 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if want := "command 'sh -c echo out; echo 'fatal: bad' >&2; exit 2' exited with code 2: fatal: bad"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+
+	_, err = e.Execute(context.Background(), Command{Name: "rivet-no-such-command"})
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("expected a NotFoundError, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = e.Execute(ctx, Command{Name: "true"})
+	var cancelled *CancelledError
+	if !errors.As(err, &cancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a CancelledError, got %v", err)
+	}
+}
+
+func TestExecuteEnvironmentAllowList(t *testing.T) {
+	t.Setenv("RIVET_TEST_SECRET", "hunter2")
+	t.Setenv("RIVET_TEST_ALLOWED", "yes")
+	e := &OSCommandExecutor{InheritEnv: []string{"PATH", "RIVET_TEST_ALLOWED"}}
+
+	result, err := e.Execute(context.Background(), Command{
+		Name: "sh",
+		Args: []string{"-c", `echo "$RIVET_TEST_ALLOWED,$RIVET_TEST_SECRET,$RIVET_TEST_EXTRA"`},
+		Env:  []string{"RIVET_TEST_EXTRA=extra"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Stdout != "yes,,extra\n" {
+		t.Errorf("stdout = %q, want only allow-listed and explicit variables", result.Stdout)
+	}
+}
+
+func TestExecuteInheritsProxyByDefault(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("no_proxy", "localhost")
+	e := NewOSCommandExecutor()
+
+	result, err := e.Execute(context.Background(), Command{Name: "sh", Args: []string{"-c", `echo "$HTTPS_PROXY,$no_proxy"`}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Stdout != "http://proxy.example.com:3128,localhost\n" {
+		t.Errorf("stdout = %q, want the proxy settings passed through", result.Stdout)
+	}
+}
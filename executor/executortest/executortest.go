@@ -0,0 +1,230 @@
+// Package executortest provides a scripted executor.CommandExecutor for tests, and one that
+// replays a recorded session.
+//
+// A Fake answers each command with the first matching expectation that still has uses left,
+// in the order the expectations were added. Commands nothing matches fail and are recorded
+// so that Verify can report them along with expectations that were never met.
+package executortest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+// Expectation scripts the response to one command. Its methods return the expectation so
+// they can be chained.
+type Expectation struct {
+	name     string
+	args     []string
+	dir      string
+	anyDir   bool
+	times    int // how often the expectation may match; negative means unlimited
+	used     int
+	result   func(context.Context, executor.Command) (*executor.Result, error)
+	stdout   string
+	stderr   string
+	exitCode int
+}
+
+// InDir restricts the expectation to commands run in dir. By default any directory matches.
+func (e *Expectation) InDir(dir string) *Expectation {
+	e.dir, e.anyDir = dir, false
+	return e
+}
+
+// Returns sets the stdout of the canned result.
+func (e *Expectation) Returns(stdout string) *Expectation {
+	e.stdout = stdout
+	return e
+}
+
+// Stderr sets the stderr of the canned result.
+func (e *Expectation) Stderr(stderr string) *Expectation {
+	e.stderr = stderr
+	return e
+}
+
+// ExitCode makes the command exit with code, which yields an *executor.ExitError when non-zero.
+func (e *Expectation) ExitCode(code int) *Expectation {
+	e.exitCode = code
+	return e
+}
+
+// Fails makes the command return err instead of a canned result, e.g. an
+// *executor.NotFoundError or an *executor.TimeoutError.
+func (e *Expectation) Fails(err error) *Expectation {
+	e.result = func(_ context.Context, cmd executor.Command) (*executor.Result, error) {
+		return &executor.Result{Command: cmd, ExitCode: -1}, err
+	}
+	return e
+}
+
+// Hangs makes the command block until its context is done, then fail the way the OS executor
+// reports a command it had to kill: with an *executor.TimeoutError once the deadline has passed,
+// otherwise with an *executor.CancelledError.
+func (e *Expectation) Hangs() *Expectation {
+	e.result = func(ctx context.Context, cmd executor.Command) (*executor.Result, error) {
+		started := time.Now()
+		<-ctx.Done()
+		result := &executor.Result{Command: cmd, Duration: time.Since(started), ExitCode: -1}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			result.TimedOut = true
+			return result, &executor.TimeoutError{Result: result}
+		}
+		return result, &executor.CancelledError{Result: result}
+	}
+	return e
+}
+
+// Times sets how often the expectation may match; the default is once.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// AnyTimes lets the expectation match any number of times, including never.
+func (e *Expectation) AnyTimes() *Expectation {
+	e.times = -1
+	return e
+}
+
+func (e *Expectation) matches(cmd executor.Command) bool {
+	if e.times >= 0 && e.used >= e.times {
+		return false
+	}
+	return e.name == cmd.Name && slices.Equal(e.args, cmd.Args) && (e.anyDir || e.dir == cmd.Dir)
+}
+
+func (e *Expectation) respond(ctx context.Context, cmd executor.Command) (*executor.Result, error) {
+	if e.result != nil {
+		return e.result(ctx, cmd)
+	}
+	result := &executor.Result{Command: cmd, ExitCode: e.exitCode, Stdout: e.stdout, Stderr: e.stderr}
+	if e.exitCode != 0 {
+		return result, &executor.ExitError{Result: result}
+	}
+	return result, nil
+}
+
+func (e *Expectation) String() string {
+	s := strings.TrimSpace(e.name + " " + strings.Join(e.args, " "))
+	if !e.anyDir {
+		s += " (in " + e.dir + ")"
+	}
+	return s
+}
+
+// Fake is a scripted executor.CommandExecutor. It is safe for concurrent use.
+type Fake struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []executor.Command
+	unexpected   []executor.Command
+}
+
+// New returns a Fake with no expectations.
+func New() *Fake {
+	return &Fake{}
+}
+
+// Expect adds an expectation for the command with exactly these arguments. It matches once and
+// succeeds with empty output unless configured otherwise.
+func (f *Fake) Expect(name string, args ...string) *Expectation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := &Expectation{name: name, args: args, anyDir: true, times: 1}
+	f.expectations = append(f.expectations, e)
+	return e
+}
+
+// Execute answers cmd from the first matching expectation, or fails and records it as unexpected.
+func (f *Fake) Execute(ctx context.Context, cmd executor.Command) (*executor.Result, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, cmd)
+	var match *Expectation
+	for _, e := range f.expectations {
+		if e.matches(cmd) {
+			e.used++
+			match = e
+			break
+		}
+	}
+	if match == nil {
+		f.unexpected = append(f.unexpected, cmd)
+	}
+	f.mu.Unlock()
+
+	// Respond without holding the lock so a hanging command does not block concurrent ones.
+	if match == nil {
+		return &executor.Result{Command: cmd, ExitCode: -1}, fmt.Errorf("executortest: unexpected command '%s' in '%s'", cmd, cmd.Dir)
+	}
+	return match.respond(ctx, cmd)
+}
+
+// Calls returns every command executed so far, in order.
+func (f *Fake) Calls() []executor.Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// Unexpected returns the commands that no expectation matched.
+func (f *Fake) Unexpected() []executor.Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.unexpected)
+}
+
+// Unmet returns the expectations that were matched fewer times than required.
+func (f *Fake) Unmet() []*Expectation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var unmet []*Expectation
+	for _, e := range f.expectations {
+		if e.times > 0 && e.used < e.times {
+			unmet = append(unmet, e)
+		}
+	}
+	return unmet
+}
+
+// Verify reports unexpected commands and unmet expectations as test errors.
+func (f *Fake) Verify(t testing.TB) {
+	t.Helper()
+	for _, cmd := range f.Unexpected() {
+		t.Errorf("unexpected command '%s' in '%s'", cmd, cmd.Dir)
+	}
+	for _, e := range f.Unmet() {
+		t.Errorf("expected command '%s' was called %d of %d times", e, e.used, e.times)
+	}
+}
+
+// Replay answers commands from a recorded session through an executor.Replayer, and adds Verify.
+type Replay struct {
+	*executor.Replayer
+}
+
+// NewReplay returns a Replay of the commands recorded in session.
+func NewReplay(session *executor.Session) *Replay {
+	return &Replay{Replayer: executor.NewReplayer(session)}
+}
+
+// Verify reports commands that are not in the session and recorded commands that never ran as
+// test errors.
+func (r *Replay) Verify(t testing.TB) {
+	t.Helper()
+	for _, cmd := range r.Unexpected() {
+		t.Errorf("unexpected command '%s' in '%s'", cmd, cmd.Dir)
+	}
+	for _, cmd := range r.Unrun() {
+		t.Errorf("recorded command '%s' in '%s' was never run", cmd, cmd.Dir)
+	}
+}
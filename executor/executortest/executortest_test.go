@@ -0,0 +1,70 @@
+package executortest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+func TestFakeScriptedResponses(t *testing.T) {
+	f := New()
+	f.Expect("git", "rev-parse", "HEAD").InDir("/srv/app").Returns("abc\n")
+	f.Expect("git", "merge-base", "--is-ancestor", "a", "b").ExitCode(1)
+	f.Expect("docker", "compose", "ps").AnyTimes()
+
+	ctx := context.Background()
+	result, err := f.Execute(ctx, executor.Command{Name: "git", Args: []string{"rev-parse", "HEAD"}, Dir: "/srv/app"})
+	if err != nil || result.Stdout != "abc\n" {
+		t.Fatalf("unexpected response %+v, %v", result, err)
+	}
+	if _, err := f.Execute(ctx, executor.Command{Name: "git", Args: []string{"merge-base", "--is-ancestor", "a", "b"}}); !executor.IsExitCode(err, 1) {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+
+	// The rev-parse expectation is used up, so a second call is unexpected.
+	if _, err := f.Execute(ctx, executor.Command{Name: "git", Args: []string{"rev-parse", "HEAD"}, Dir: "/srv/app"}); err == nil {
+		t.Error("expected an error for an unexpected command")
+	}
+	if got := f.Unexpected(); len(got) != 1 || got[0].Name != "git" {
+		t.Errorf("unexpected calls = %v", got)
+	}
+	if len(f.Unmet()) != 0 {
+		t.Errorf("expected all expectations to be met, got %v", f.Unmet())
+	}
+}
+
+func TestFakeHangsUntilDeadline(t *testing.T) {
+	f := New()
+	f.Expect("docker", "compose", "run", "--rm", "tests").Hangs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	result, err := f.Execute(ctx, executor.Command{Name: "docker", Args: []string{"compose", "run", "--rm", "tests"}})
+	var timeoutErr *executor.TimeoutError
+	if !errors.As(err, &timeoutErr) || !result.TimedOut {
+		t.Errorf("expected the hanging command to time out, got %+v, %v", result, err)
+	}
+	f.Verify(t)
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	cmd := executor.Command{Name: "git", Args: []string{"fetch", "origin"}, Dir: "/srv/app"}
+	failed := &executor.Result{Command: cmd, ExitCode: 128, Stderr: "fatal: unable to access\n"}
+	session := &executor.Session{Invocations: []executor.Invocation{
+		executor.NewInvocation(failed, &executor.ExitError{Result: failed}),
+		executor.NewInvocation(&executor.Result{Command: cmd}, nil),
+	}}
+
+	r := NewReplay(session)
+	if _, err := r.Execute(ctx, cmd); !executor.IsExitCode(err, 128) {
+		t.Errorf("expected the recorded failure first, got %v", err)
+	}
+	if _, err := r.Execute(ctx, cmd); err != nil {
+		t.Errorf("expected the recorded success second, got %v", err)
+	}
+	r.Verify(t)
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package executor
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// useProcessGroup falls back to killing only the direct child where process groups are unavailable.
+func useProcessGroup(cmd *exec.Cmd, grace time.Duration) {
+	cmd.WaitDelay = grace
+}
+
+func useCredential(cmd *exec.Cmd, cred *Credential) error {
+	return errors.New("running commands as another user is not supported on this platform")
+}
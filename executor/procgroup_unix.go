@@ -0,0 +1,46 @@
+//go:build unix
+
+package executor
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// useProcessGroup starts cmd as the leader of a new process group. On cancellation the whole
+// group gets SIGTERM, and SIGKILL once grace has passed, so nothing it spawned is orphaned.
+func useProcessGroup(cmd *exec.Cmd, grace time.Duration) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		// This outlives Wait on purpose: children that ignored SIGTERM must still be killed.
+		go killGroupAfter(pgid, grace)
+		return syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+	// Stop waiting for output pipes held open by anything that escaped the group.
+	cmd.WaitDelay = grace + time.Second
+}
+
+// groupPollInterval is how often killGroupAfter checks whether the group has exited.
+const groupPollInterval = 100 * time.Millisecond
+
+// killGroupAfter sends SIGKILL to the process group once grace has passed, unless the group
+// exits first. Once it is gone its id may be reused by an unrelated group, which must not be hit.
+func killGroupAfter(pgid int, grace time.Duration) {
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		time.Sleep(min(groupPollInterval, time.Until(deadline)))
+		if err := syscall.Kill(-pgid, 0); errors.Is(err, syscall.ESRCH) {
+			return
+		}
+	}
+	_ = syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+// useCredential makes cmd run as the given user. It must be called after useProcessGroup.
+func useCredential(cmd *exec.Cmd, cred *Credential) error {
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: cred.UID, Gid: cred.GID, Groups: []uint32{}}
+	return nil
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCancelKillsProcessGroup(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "survived")
+	e := &OSCommandExecutor{KillGracePeriod: 200 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	// The shell ignores SIGTERM, so only the group-wide SIGKILL stops it; the background
+	// job would be orphaned and create the marker if only the direct child were signalled.
+	cmd := Command{Name: "sh", Args: []string{"-c", "trap '' TERM; (sleep 1; touch " + marker + ") & wait"}}
+	result, err := e.Execute(ctx, cmd)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if !result.TimedOut || result.ExitCode != -1 || result.Signal != "killed" {
+		t.Errorf("result = %+v, want a timed-out command killed by SIGKILL", result)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("background job survived cancellation")
+	}
+}
+
+func TestKillGroupAfterStopsOnceGroupExits(t *testing.T) {
+	e := &OSCommandExecutor{}
+	cmd, err := e.newCommand(context.Background(), Command{Name: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The group is gone, so its id must be left alone rather than killed when grace runs out.
+	started := time.Now()
+	killGroupAfter(cmd.Process.Pid, 10*time.Second)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("killGroupAfter waited %s for a group that had already exited", elapsed)
+	}
+}
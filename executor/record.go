@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"context"
+	"sync"
+)
+
+type sessionKey struct{}
+
+// WithSession returns a context under which a Recorder appends every command it runs to session.
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// Recorder is a CommandExecutor that records the commands run under a WithSession context, with
+// their results, and passes everything else straight through. Streamed commands are recorded with
+// the output tails the wrapped executor retained.
+type Recorder struct {
+	Executor CommandExecutor
+}
+
+// NewRecorder wraps exec in a Recorder.
+func NewRecorder(exec CommandExecutor) *Recorder {
+	return &Recorder{Executor: exec}
+}
+
+func (r *Recorder) Execute(ctx context.Context, cmd Command) (*Result, error) {
+	result, err := r.Executor.Execute(ctx, cmd)
+	record(ctx, result, err)
+	return result, err
+}
+
+func (r *Recorder) ExecuteStream(ctx context.Context, cmd Command, onLine LineHandler) (*Result, error) {
+	result, err := ExecuteStream(ctx, r.Executor, cmd, onLine)
+	record(ctx, result, err)
+	return result, err
+}
+
+func record(ctx context.Context, result *Result, err error) {
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	if !ok {
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.Invocations = append(session.Invocations, NewInvocation(result, err))
+}
+
+// sessionMutex is embedded in Session so that concurrent commands can record into it.
+type sessionMutex struct {
+	mu sync.Mutex
+}
@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderRecordsSessionRoundTrip(t *testing.T) {
+	r := NewRecorder(NewOSCommandExecutor())
+	session := &Session{}
+	ctx := WithSession(context.Background(), session)
+
+	if _, err := r.Execute(context.Background(), Command{Name: "true"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.Execute(ctx, Command{Name: "sh", Args: []string{"-c", "echo out; exit 3"}})
+	var lines []Line
+	r.ExecuteStream(ctx, Command{Name: "echo", Args: []string{"streamed"}}, func(l Line) { lines = append(lines, l) })
+	if len(lines) != 1 || lines[0].Text != "streamed" {
+		t.Errorf("expected the streamed line to be delivered, got %+v", lines)
+	}
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := session.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Invocations) != 2 {
+		t.Fatalf("expected only the two commands run under the session, got %+v", loaded.Invocations)
+	}
+	result, err := loaded.Invocations[0].Outcome()
+	if !IsExitCode(err, 3) || result.Stdout != "out\n" {
+		t.Errorf("expected the recorded exit error to be rebuilt, got %+v, %v", result, err)
+	}
+	if inv := loaded.Invocations[1]; inv.Name != "echo" || inv.Stdout != "streamed\n" {
+		t.Errorf("unexpected streamed invocation %+v", inv)
+	}
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Replayer is a CommandExecutor that answers commands with the results recorded in a session
+// instead of running them. Each command is matched, by name, arguments and directory, to the first
+// recorded invocation of it not yet used, so repeated commands get their results in recorded order.
+type Replayer struct {
+	mu          sync.Mutex
+	invocations []Invocation
+	used        []bool
+	unexpected  []Command
+}
+
+// NewReplayer creates a Replayer for the invocations of session.
+func NewReplayer(session *Session) *Replayer {
+	return &Replayer{
+		invocations: session.Invocations,
+		used:        make([]bool, len(session.Invocations)),
+	}
+}
+
+// Execute returns the recorded outcome of cmd, or fails if the session holds no unused invocation of it.
+func (p *Replayer) Execute(ctx context.Context, cmd Command) (*Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, inv := range p.invocations {
+		if p.used[i] || inv.Name != cmd.Name || inv.Dir != cmd.Dir || !slices.Equal(inv.Args, cmd.Args) {
+			continue
+		}
+		p.used[i] = true
+		result, err := inv.Outcome()
+		result.Command = cmd
+		return result, err
+	}
+	p.unexpected = append(p.unexpected, cmd)
+	return &Result{Command: cmd, ExitCode: -1}, fmt.Errorf("command '%s' in '%s' is not in the recorded session", cmd, cmd.Dir)
+}
+
+// Unexpected returns the commands that were not in the session, in the order they were run.
+func (p *Replayer) Unexpected() []Command {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.unexpected)
+}
+
+// Unrun returns the recorded commands that were never run.
+func (p *Replayer) Unrun() []Command {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var unrun []Command
+	for i, inv := range p.invocations {
+		if !p.used[i] {
+			unrun = append(unrun, inv.Command())
+		}
+	}
+	return unrun
+}
@@ -0,0 +1,34 @@
+package executor
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReplayerAnswersInRecordedOrder(t *testing.T) {
+	ctx := context.Background()
+	cmd := Command{Name: "git", Args: []string{"fetch", "origin"}, Dir: "/srv/app"}
+	failed := &Result{Command: cmd, ExitCode: 128, Stderr: "fatal: unable to access\n"}
+	session := &Session{Invocations: []Invocation{
+		NewInvocation(failed, &ExitError{Result: failed}),
+		NewInvocation(&Result{Command: cmd}, nil),
+		NewInvocation(&Result{Command: Command{Name: "docker", Args: []string{"compose", "build"}}}, nil),
+	}}
+
+	p := NewReplayer(session)
+	if _, err := p.Execute(ctx, cmd); !IsExitCode(err, 128) {
+		t.Errorf("expected the recorded failure first, got %v", err)
+	}
+	if _, err := p.Execute(ctx, cmd); err != nil {
+		t.Errorf("expected the recorded success second, got %v", err)
+	}
+	if _, err := p.Execute(ctx, cmd); err == nil {
+		t.Error("expected a third fetch not to be in the session")
+	}
+	if got := p.Unexpected(); len(got) != 1 || got[0].String() != "git fetch origin" {
+		t.Errorf("unexpected = %v", got)
+	}
+	if got := p.Unrun(); len(got) != 1 || got[0].Name != "docker" {
+		t.Errorf("unrun = %v", got)
+	}
+}
@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RetryPolicy describes how often and how fast a transient failure is retried.
+type RetryPolicy struct {
+	Attempts     int           // tries in total, including the first; 1 or less disables retries
+	InitialDelay time.Duration // wait before the first retry, doubled for each one after it
+	MaxDelay     time.Duration // cap on the wait; zero means no cap
+}
+
+// Delay returns the wait before the given retry, counting from 1: exponential backoff capped at
+// MaxDelay, jittered down by up to half so that repositories hit by the same outage spread out.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	d := p.InitialDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d - rand.N(d/2+1)
+}
+
+// transientPattern matches error output of git and docker that points at a failure worth retrying:
+// network trouble, HTTP 5xx responses from a git host or registry, and another git process
+// holding a lock such as .git/index.lock.
+var transientPattern = regexp.MustCompile(`(?i)` + strings.Join([]string{
+	`could not resolve host`,
+	`temporary failure in name resolution`,
+	`connection (timed out|refused|reset)`,
+	`operation timed out`,
+	`network is unreachable`,
+	`i/o timeout`,
+	`tls handshake timeout`,
+	`gnutls_handshake\(\) failed`,
+	`ssl_error_syscall`,
+	`the remote end hung up unexpectedly`,
+	`early eof`,
+	`unexpected disconnect`,
+	`rpc failed`,
+	`(error|status|status code|http)[: ]+5\d\d\b`,
+	`\b5\d\d (internal server error|bad gateway|service unavailable|gateway time-?out)`,
+	`\.lock': file exists`,
+}, "|"))
+
+// IsTransient reports whether a command that exited non-zero failed for a reason that may well go
+// away on its own, judging by its error output. Commands that could not start, timed out or were
+// cancelled are never transient.
+func IsTransient(result *Result, err error) bool {
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	return transientPattern.MatchString(result.Stderr)
+}
+
+// Retry describes a failed attempt that is about to be retried.
+type Retry struct {
+	Command Command
+	Attempt int // the attempt that failed, counting from 1
+	Err     error
+	Delay   time.Duration // wait before the next attempt
+}
+
+type retryObserverKey struct{}
+
+// WithRetryObserver returns a context under which a Retrier reports every retry to observe
+// before waiting for it.
+func WithRetryObserver(ctx context.Context, observe func(Retry)) context.Context {
+	return context.WithValue(ctx, retryObserverKey{}, observe)
+}
+
+// Retrier is a CommandExecutor that runs a Retryable command again, following Policy, when it
+// fails in a way Transient accepts. Other commands and failures pass straight through.
+type Retrier struct {
+	Executor CommandExecutor
+	Policy   RetryPolicy
+	// Transient classifies failures; nil means IsTransient.
+	Transient func(*Result, error) bool
+}
+
+// NewRetrier wraps exec in a Retrier using policy.
+func NewRetrier(exec CommandExecutor, policy RetryPolicy) *Retrier {
+	return &Retrier{Executor: exec, Policy: policy}
+}
+
+func (r *Retrier) Execute(ctx context.Context, cmd Command) (*Result, error) {
+	return r.retry(ctx, cmd, func() (*Result, error) {
+		return r.Executor.Execute(ctx, cmd)
+	})
+}
+
+// ExecuteStream streams every attempt, so the output of a failed attempt is delivered before the
+// output of the retry.
+func (r *Retrier) ExecuteStream(ctx context.Context, cmd Command, onLine LineHandler) (*Result, error) {
+	return r.retry(ctx, cmd, func() (*Result, error) {
+		return ExecuteStream(ctx, r.Executor, cmd, onLine)
+	})
+}
+
+func (r *Retrier) retry(ctx context.Context, cmd Command, attempt func() (*Result, error)) (*Result, error) {
+	transient := r.Transient
+	if transient == nil {
+		transient = IsTransient
+	}
+	observe, _ := ctx.Value(retryObserverKey{}).(func(Retry))
+
+	for n := 1; ; n++ {
+		result, err := attempt()
+		if err == nil || !cmd.Retryable || n >= r.Policy.Attempts || !transient(result, err) {
+			return result, err
+		}
+		delay := r.Policy.Delay(n)
+		if observe != nil {
+			observe(Retry{Command: cmd, Attempt: n, Err: err, Delay: delay})
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		}
+	}
+}
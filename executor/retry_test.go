@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// scriptedExecutor fails each command with the next stderr in its script, exiting 0 once it runs out.
+type scriptedExecutor struct {
+	stderr []string
+	calls  int
+}
+
+func (s *scriptedExecutor) Execute(ctx context.Context, cmd Command) (*Result, error) {
+	s.calls++
+	if len(s.stderr) == 0 {
+		return &Result{Command: cmd}, nil
+	}
+	result := &Result{Command: cmd, ExitCode: 128, Stderr: s.stderr[0]}
+	s.stderr = s.stderr[1:]
+	return result, &ExitError{Result: result}
+}
+
+func TestIsTransient(t *testing.T) {
+	for stderr, want := range map[string]bool{
+		"fatal: unable to access 'https://example.com/app.git/': Could not resolve host: example.com":   true,
+		"fatal: unable to access 'https://example.com/app.git/': The requested URL returned error: 502": true,
+		"failed to solve: unexpected status from HEAD request: 503 Service Unavailable":                 true,
+		"fatal: Unable to create '/srv/app/.git/index.lock': File exists.":                              true,
+		"fatal: the remote end hung up unexpectedly":                                                    true,
+		"fatal: couldn't find remote ref refs/heads/missing":                                            false,
+		"fatal: The requested URL returned error: 404":                                                  false,
+	} {
+		result := &Result{ExitCode: 128, Stderr: stderr}
+		if got := IsTransient(result, &ExitError{Result: result}); got != want {
+			t.Errorf("IsTransient(%q) = %v, want %v", stderr, got, want)
+		}
+	}
+
+	result := &Result{ExitCode: -1, Stderr: "Could not resolve host", TimedOut: true}
+	if IsTransient(result, &TimeoutError{Result: result}) {
+		t.Error("a timed out command must not be transient")
+	}
+}
+
+func TestRetrierRetriesTransientFailures(t *testing.T) {
+	inner := &scriptedExecutor{stderr: []string{"fatal: Could not resolve host: example.com", "error: RPC failed; HTTP 503"}}
+	r := NewRetrier(inner, RetryPolicy{Attempts: 3, InitialDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond})
+	var retries []Retry
+	ctx := WithRetryObserver(context.Background(), func(retry Retry) { retries = append(retries, retry) })
+
+	if _, err := r.Execute(ctx, Command{Name: "git", Args: []string{"fetch"}, Retryable: true}); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if inner.calls != 3 || len(retries) != 2 || retries[1].Attempt != 2 || retries[1].Delay > 2*time.Millisecond {
+		t.Errorf("unexpected attempts: %d calls, retries %+v", inner.calls, retries)
+	}
+
+	inner = &scriptedExecutor{stderr: []string{"fatal: Could not resolve host", "fatal: Could not resolve host", "fatal: Could not resolve host"}}
+	r.Executor = inner
+	if _, err := r.Execute(ctx, Command{Name: "git", Args: []string{"fetch"}, Retryable: true}); !IsExitCode(err, 128) || inner.calls != 3 {
+		t.Errorf("expected to give up after 3 attempts, got %v after %d calls", err, inner.calls)
+	}
+
+	inner = &scriptedExecutor{stderr: []string{"connection refused"}}
+	r.Executor = inner
+	if _, err := r.Execute(ctx, Command{Name: "sh", Args: []string{"-c", "./test.sh"}}); err == nil || inner.calls != 1 {
+		t.Errorf("expected a command that is not retryable to run once, got %v after %d calls", err, inner.calls)
+	}
+}
@@ -0,0 +1,138 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Error kinds stored in a session, one per error type returned by Execute.
+const (
+	ErrorKindExit      = "exit"
+	ErrorKindNotFound  = "not-found"
+	ErrorKindTimeout   = "timeout"
+	ErrorKindCancelled = "cancelled"
+	ErrorKindOther     = "other"
+)
+
+// Invocation is one command and its outcome as stored in a session. The environment is left
+// out on purpose: it may hold credentials and does not affect how rivet reacts to the result.
+type Invocation struct {
+	Name      string        `json:"name"`
+	Args      []string      `json:"args,omitempty"`
+	Dir       string        `json:"dir,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	ExitCode  int           `json:"exitCode"`
+	Stdout    string        `json:"stdout,omitempty"`
+	Stderr    string        `json:"stderr,omitempty"`
+	Signal    string        `json:"signal,omitempty"`
+	TimedOut  bool          `json:"timedOut,omitempty"`
+	ErrorKind string        `json:"errorKind,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Session is an ordered list of invocations, as captured from a real run. Meta carries whatever
+// the recording side needs to set the scene for a replay.
+type Session struct {
+	sessionMutex
+	StartedAt   time.Time         `json:"startedAt"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	Invocations []Invocation      `json:"invocations"`
+}
+
+// NewInvocation captures the outcome of Execute.
+func NewInvocation(result *Result, err error) Invocation {
+	inv := Invocation{
+		Name:     result.Name,
+		Args:     result.Args,
+		Dir:      result.Dir,
+		Duration: result.Duration,
+		ExitCode: result.ExitCode,
+		Stdout:   result.Stdout,
+		Stderr:   result.Stderr,
+		Signal:   result.Signal,
+		TimedOut: result.TimedOut,
+	}
+	if err == nil {
+		return inv
+	}
+	inv.Error = err.Error()
+	var (
+		exitErr     *ExitError
+		notFoundErr *NotFoundError
+		timeoutErr  *TimeoutError
+		cancelErr   *CancelledError
+	)
+	switch {
+	case errors.As(err, &exitErr):
+		inv.ErrorKind = ErrorKindExit
+	case errors.As(err, &notFoundErr):
+		inv.ErrorKind = ErrorKindNotFound
+	case errors.As(err, &timeoutErr):
+		inv.ErrorKind = ErrorKindTimeout
+	case errors.As(err, &cancelErr):
+		inv.ErrorKind = ErrorKindCancelled
+	default:
+		inv.ErrorKind = ErrorKindOther
+	}
+	return inv
+}
+
+// Command returns the command that was invoked, without its environment.
+func (inv Invocation) Command() Command {
+	return Command{Name: inv.Name, Args: inv.Args, Dir: inv.Dir}
+}
+
+// Outcome rebuilds the Result and the typed error Execute returned for the invocation.
+func (inv Invocation) Outcome() (*Result, error) {
+	result := &Result{
+		Command:  inv.Command(),
+		Duration: inv.Duration,
+		ExitCode: inv.ExitCode,
+		Stdout:   inv.Stdout,
+		Stderr:   inv.Stderr,
+		Signal:   inv.Signal,
+		TimedOut: inv.TimedOut,
+	}
+	switch inv.ErrorKind {
+	case "":
+		return result, nil
+	case ErrorKindExit:
+		return result, &ExitError{Result: result}
+	case ErrorKindNotFound:
+		return result, &NotFoundError{Name: inv.Name, Err: errors.New(inv.Error)}
+	case ErrorKindTimeout:
+		return result, &TimeoutError{Result: result}
+	case ErrorKindCancelled:
+		return result, &CancelledError{Result: result}
+	default:
+		return result, errors.New(inv.Error)
+	}
+}
+
+// LoadSession reads a session file.
+func LoadSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file '%s': %w", path, err)
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse session file '%s': %w", path, err)
+	}
+	return &session, nil
+}
+
+// Save writes the session to path as indented JSON.
+func (s *Session) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write session file '%s': %w", path, err)
+	}
+	return nil
+}
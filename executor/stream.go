@@ -0,0 +1,146 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTailBytes is how much of each output stream ExecuteStream retains for error messages.
+const DefaultTailBytes = 64 * 1024
+
+// Stream identifies which output stream a line was written to.
+type Stream string
+
+const (
+	Stdout Stream = "stdout"
+	Stderr Stream = "stderr"
+)
+
+// Line is a single line of command output, without its trailing newline.
+type Line struct {
+	Stream Stream
+	Text   string
+}
+
+// LineHandler receives command output as it is produced. Calls are serialised per command.
+type LineHandler func(Line)
+
+// StreamingExecutor is a CommandExecutor that can also deliver output while a command runs.
+// The Result of ExecuteStream holds only the retained tail of each stream.
+type StreamingExecutor interface {
+	CommandExecutor
+	ExecuteStream(ctx context.Context, cmd Command, onLine LineHandler) (*Result, error)
+}
+
+// ExecuteStream runs the command like Execute but hands each line of output to onLine as soon as
+// it is written, keeping at most TailBytes (DefaultTailBytes when zero) of each stream in memory.
+func (e *OSCommandExecutor) ExecuteStream(ctx context.Context, command Command, onLine LineHandler) (*Result, error) {
+	cmd, err := e.newCommand(ctx, command)
+	if err != nil {
+		return &Result{Command: command, ExitCode: -1}, err
+	}
+
+	limit := e.TailBytes
+	if limit <= 0 {
+		limit = DefaultTailBytes
+	}
+	var mu sync.Mutex
+	outWriter := &lineWriter{stream: Stdout, onLine: onLine, mu: &mu, tail: tailBuffer{limit: limit}}
+	errWriter := &lineWriter{stream: Stderr, onLine: onLine, mu: &mu, tail: tailBuffer{limit: limit}}
+	cmd.Stdout = outWriter
+	cmd.Stderr = errWriter
+
+	started := time.Now()
+	err = cmd.Run()
+	outWriter.flush()
+	errWriter.flush()
+
+	return finish(ctx, cmd, command, time.Since(started), err, outWriter.tail.String(), errWriter.tail.String())
+}
+
+// ExecuteStream runs cmd on exec, streaming when exec supports it and otherwise delivering the
+// output line by line once the command has finished.
+func ExecuteStream(ctx context.Context, exec CommandExecutor, cmd Command, onLine LineHandler) (*Result, error) {
+	if streamer, ok := exec.(StreamingExecutor); ok {
+		return streamer.ExecuteStream(ctx, cmd, onLine)
+	}
+	result, err := exec.Execute(ctx, cmd)
+	for _, output := range []struct {
+		stream Stream
+		text   string
+	}{{Stdout, result.Stdout}, {Stderr, result.Stderr}} {
+		if output.text == "" {
+			continue
+		}
+		for _, text := range strings.Split(strings.TrimSuffix(output.text, "\n"), "\n") {
+			onLine(Line{Stream: output.stream, Text: text})
+		}
+	}
+	return result, err
+}
+
+// lineWriter splits written bytes into lines for a LineHandler and keeps a bounded tail.
+type lineWriter struct {
+	stream  Stream
+	onLine  LineHandler
+	mu      *sync.Mutex // shared by stdout and stderr so the handler is never called concurrently
+	partial []byte
+	tail    tailBuffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.tail.Write(p)
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+	// A line longer than the tail is delivered in pieces rather than buffered without bound.
+	if len(w.partial) >= w.tail.limit {
+		w.emit(w.partial)
+		w.partial = nil
+	}
+	return len(p), nil
+}
+
+// flush delivers a final line that was not terminated by a newline.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.emit(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *lineWriter) emit(b []byte) {
+	if w.onLine != nil {
+		w.onLine(Line{Stream: w.stream, Text: string(bytes.TrimSuffix(b, []byte("\r")))})
+	}
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
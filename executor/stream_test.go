@@ -0,0 +1,40 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExecuteStreamDeliversLinesAndKeepsTail(t *testing.T) {
+	e := &OSCommandExecutor{TailBytes: 8}
+	var lines []Line
+	cmd := Command{Name: "sh", Args: []string{"-c", "printf 'one\\ntwo\\nthree'; echo oops >&2; exit 3"}}
+	result, err := e.ExecuteStream(context.Background(), cmd, func(l Line) {
+		lines = append(lines, l)
+	})
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || result.ExitCode != 3 {
+		t.Fatalf("expected exit 3 with an ExitError, got %d, %v", result.ExitCode, err)
+	}
+	if result.Stdout != "wo\nthree" {
+		t.Errorf("stdout tail = %q", result.Stdout)
+	}
+	if result.Stderr != "oops\n" {
+		t.Errorf("stderr tail = %q", result.Stderr)
+	}
+
+	// Streams are read concurrently, so only the order within each stream is defined.
+	got := map[Stream][]string{}
+	for _, l := range lines {
+		got[l.Stream] = append(got[l.Stream], l.Text)
+	}
+	if s := strings.Join(got[Stdout], ","); s != "one,two,three" {
+		t.Errorf("stdout lines = %q", s)
+	}
+	if s := strings.Join(got[Stderr], ","); s != "oops" {
+		t.Errorf("stderr lines = %q", s)
+	}
+}
@@ -0,0 +1,114 @@
+package gitclient
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+// ExecGitClient implements GitClient by running the git binary through a CommandExecutor.
+type ExecGitClient struct {
+	Executor executor.CommandExecutor
+	// GlobalArgs are placed before every git subcommand, e.g. `-c` options carrying credentials.
+	GlobalArgs []string
+	// Env is set for every git invocation, e.g. the variable a credential helper reads.
+	Env []string
+	// Credential runs git as another user; nil keeps the caller's privileges.
+	Credential *executor.Credential
+}
+
+// NewExecGitClient creates a GitClient that runs git through exec.
+func NewExecGitClient(exec executor.CommandExecutor, globalArgs ...string) *ExecGitClient {
+	return &ExecGitClient{Executor: exec, GlobalArgs: globalArgs}
+}
+
+func (c *ExecGitClient) run(ctx context.Context, dir string, args ...string) (string, error) {
+	result, err := c.Executor.Execute(ctx, executor.Command{Name: "git", Args: append(append([]string{}, c.GlobalArgs...), args...), Dir: dir, Env: c.Env, Credential: c.Credential, Retryable: true})
+	if err != nil {
+		return result.Stdout, fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return result.Stdout, nil
+}
+
+func (c *ExecGitClient) Clone(ctx context.Context, opts CloneOptions) error {
+	args := []string{"clone"}
+	if opts.Branch != "" {
+		args = append(args, "-b", opts.Branch)
+	}
+	if opts.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.Filter != "" {
+		args = append(args, "--filter="+opts.Filter)
+	}
+	if opts.Sparse {
+		args = append(args, "--sparse")
+	}
+	if opts.Reference != "" {
+		args = append(args, "--reference-if-able", opts.Reference)
+	}
+	args = append(args, opts.URL, filepath.Base(opts.Dir))
+	_, err := c.run(ctx, filepath.Dir(opts.Dir), args...)
+	return err
+}
+
+func (c *ExecGitClient) Fetch(ctx context.Context, dir string, opts FetchOptions) error {
+	args := []string{"fetch", opts.remote()}
+	args = append(args, opts.RefSpecs...)
+	if opts.Tags {
+		args = append(args, "--tags")
+	}
+	if opts.Prune {
+		args = append(args, "--prune")
+		if opts.Tags {
+			args = append(args, "--prune-tags")
+		}
+	}
+	if opts.Force {
+		args = append(args, "--force")
+	}
+	if opts.UploadPack != "" {
+		args = append(args, "--upload-pack="+opts.UploadPack)
+	}
+	_, err := c.run(ctx, dir, args...)
+	return err
+}
+
+func (c *ExecGitClient) RevParse(ctx context.Context, dir, rev string) (string, error) {
+	stdout, err := c.run(ctx, dir, "rev-parse", "--verify", "--quiet", rev)
+	return strings.TrimSpace(stdout), err
+}
+
+func (c *ExecGitClient) IsAncestor(ctx context.Context, dir, ancestor, descendant string) (bool, error) {
+	// merge-base --is-ancestor exits 1 when the commit is not an ancestor; anything else is an error.
+	_, err := c.run(ctx, dir, "merge-base", "--is-ancestor", ancestor, descendant)
+	switch {
+	case err == nil:
+		return true, nil
+	case executor.IsExitCode(err, 1):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (c *ExecGitClient) FastForward(ctx context.Context, dir, target string) error {
+	// merge would happily move a detached HEAD, so insist on a branch like the go-git backend.
+	if _, err := c.run(ctx, dir, "symbolic-ref", "-q", "HEAD"); err != nil {
+		if executor.IsExitCode(err, 1) {
+			return fmt.Errorf("cannot fast-forward a detached HEAD")
+		}
+		return err
+	}
+	_, err := c.run(ctx, dir, "merge", "--ff-only", target)
+	return err
+}
+
+func (c *ExecGitClient) Checkout(ctx context.Context, dir, commit string) error {
+	_, err := c.run(ctx, dir, "checkout", "--detach", commit)
+	return err
+}
@@ -0,0 +1,55 @@
+// Package gitclient defines the core git operations rivet uses to follow a remote, with an
+// implementation that runs the git binary and an in-process pure-Go implementation. Operations
+// outside GitClient always run the git binary.
+package gitclient
+
+import (
+	"context"
+)
+
+// GitClient covers the git operations needed to clone a repository and move its checkout.
+// Every method except Clone operates on the repository in dir.
+type GitClient interface {
+	// Clone clones a remote into a new directory.
+	Clone(ctx context.Context, opts CloneOptions) error
+	// Fetch downloads refs and objects from a remote name, URL or local path.
+	Fetch(ctx context.Context, dir string, opts FetchOptions) error
+	// RevParse resolves a revision such as "HEAD", "origin/main" or "v1.2.0^{commit}" to a commit SHA.
+	RevParse(ctx context.Context, dir, rev string) (string, error)
+	// IsAncestor reports whether ancestor is reachable from descendant.
+	IsAncestor(ctx context.Context, dir, ancestor, descendant string) (bool, error)
+	// FastForward moves the checked-out branch to target, failing if that is not a fast-forward.
+	FastForward(ctx context.Context, dir, target string) error
+	// Checkout checks out a commit with a detached HEAD.
+	Checkout(ctx context.Context, dir, commit string) error
+}
+
+// CloneOptions describes a clone. Filter and Sparse are only supported by the exec backend;
+// Reference is a best-effort hint that backends without alternates support ignore.
+type CloneOptions struct {
+	URL       string
+	Dir       string // absolute path of the directory to create
+	Branch    string // branch to check out; the remote's default branch when empty
+	Depth     int    // shallow clone depth; full history when zero
+	Filter    string // partial clone filter, e.g. "blob:none"
+	Sparse    bool   // start with a sparse checkout of the top-level files only
+	Reference string // local repository to borrow objects from through alternates
+}
+
+// FetchOptions describes a fetch.
+type FetchOptions struct {
+	Remote   string   // remote name, URL or local path; "origin" when empty
+	RefSpecs []string // full refspecs such as "+refs/heads/main:refs/remotes/origin/main"
+	Tags     bool     // fetch all tags
+	Prune    bool     // remove refs that no longer exist on the remote
+	Force    bool     // allow non-fast-forward updates of local refs such as moved tags
+	// UploadPack overrides the command run on the remote side; only the exec backend uses it.
+	UploadPack string
+}
+
+func (o FetchOptions) remote() string {
+	if o.Remote == "" {
+		return "origin"
+	}
+	return o.Remote
+}
@@ -0,0 +1,124 @@
+package gitclient
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+// fixture is a bare "remote" repository plus a working clone used to push commits to it.
+type fixture struct {
+	t      *testing.T
+	remote string
+	author string
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=rivet", "GIT_AUTHOR_EMAIL=rivet@example.com",
+		"GIT_COMMITTER_NAME=rivet", "GIT_COMMITTER_EMAIL=rivet@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	root := t.TempDir()
+	f := &fixture{t: t, remote: filepath.Join(root, "remote.git"), author: filepath.Join(root, "author")}
+	runGit(t, root, "init", "--bare", "-b", "main", f.remote)
+	runGit(t, root, "clone", f.remote, f.author)
+	runGit(t, f.author, "checkout", "-b", "main")
+	f.commit("first")
+	return f
+}
+
+// commit creates and pushes a commit, returning its SHA.
+func (f *fixture) commit(message string) string {
+	f.t.Helper()
+	if err := os.WriteFile(filepath.Join(f.author, "file.txt"), []byte(message), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+	runGit(f.t, f.author, "add", "file.txt")
+	runGit(f.t, f.author, "commit", "-m", message)
+	runGit(f.t, f.author, "push", "-q", "origin", "main", "--tags")
+	return runGit(f.t, f.author, "rev-parse", "HEAD")
+}
+
+func testGitClient(t *testing.T, client GitClient) {
+	ctx := context.Background()
+	f := newFixture(t)
+	first := runGit(t, f.author, "rev-parse", "HEAD")
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	if err := client.Clone(ctx, CloneOptions{URL: f.remote, Dir: dir, Branch: "main"}); err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	if head, err := client.RevParse(ctx, dir, "HEAD"); err != nil || head != first {
+		t.Fatalf("RevParse(HEAD) = %q, %v; want %q", head, err, first)
+	}
+
+	second := f.commit("second")
+	runGit(t, f.author, "tag", "-a", "v1.0.0", "-m", "release")
+	runGit(t, f.author, "push", "-q", "origin", "v1.0.0")
+
+	err := client.Fetch(ctx, dir, FetchOptions{RefSpecs: []string{"+refs/heads/main:refs/remotes/origin/main"}, Tags: true, Prune: true, Force: true})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if remote, err := client.RevParse(ctx, dir, "origin/main"); err != nil || remote != second {
+		t.Fatalf("RevParse(origin/main) = %q, %v; want %q", remote, err, second)
+	}
+	if tagged, err := client.RevParse(ctx, dir, "v1.0.0^{commit}"); err != nil || tagged != second {
+		t.Fatalf("RevParse(v1.0.0^{commit}) = %q, %v; want %q", tagged, err, second)
+	}
+
+	if ok, err := client.IsAncestor(ctx, dir, first, second); err != nil || !ok {
+		t.Fatalf("IsAncestor(first, second) = %v, %v; want true", ok, err)
+	}
+	if ok, err := client.IsAncestor(ctx, dir, second, first); err != nil || ok {
+		t.Fatalf("IsAncestor(second, first) = %v, %v; want false", ok, err)
+	}
+
+	if err := client.FastForward(ctx, dir, "origin/main"); err != nil {
+		t.Fatalf("FastForward: %v", err)
+	}
+	if head, _ := client.RevParse(ctx, dir, "HEAD"); head != second {
+		t.Fatalf("HEAD after FastForward = %q, want %q", head, second)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "file.txt")); string(content) != "second" {
+		t.Fatalf("working tree not updated by FastForward: %q", content)
+	}
+
+	if err := client.Checkout(ctx, dir, first); err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+	if head, _ := client.RevParse(ctx, dir, "HEAD"); head != first {
+		t.Fatalf("HEAD after Checkout = %q, want %q", head, first)
+	}
+	if err := client.FastForward(ctx, dir, "origin/main"); err == nil {
+		t.Fatalf("FastForward on a detached HEAD must fail")
+	}
+}
+
+func TestExecGitClient(t *testing.T) {
+	testGitClient(t, NewExecGitClient(executor.NewOSCommandExecutor()))
+}
+
+func TestGoGitClient(t *testing.T) {
+	testGitClient(t, NewGoGitClient(nil))
+}
@@ -0,0 +1,169 @@
+package gitclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// GoGitClient implements GitClient in-process with go-git. It only replaces the git binary for the
+// operations in GitClient; rivet still runs git for the rest.
+type GoGitClient struct {
+	// Auth returns the credentials for network operations. It is called for every clone and
+	// fetch so rotated tokens are picked up; a nil Auth or a nil result means no credentials.
+	Auth func() (transport.AuthMethod, error)
+}
+
+// NewGoGitClient creates a GitClient backed by go-git.
+func NewGoGitClient(auth func() (transport.AuthMethod, error)) *GoGitClient {
+	return &GoGitClient{Auth: auth}
+}
+
+func (c *GoGitClient) auth() (transport.AuthMethod, error) {
+	if c.Auth == nil {
+		return nil, nil
+	}
+	return c.Auth()
+}
+
+func (c *GoGitClient) Clone(ctx context.Context, opts CloneOptions) error {
+	// Reference is only a hint, like --reference-if-able; go-git has no alternates support.
+	if opts.Filter != "" || opts.Sparse {
+		return fmt.Errorf("go-git backend does not support partial or sparse clones")
+	}
+	auth, err := c.auth()
+	if err != nil {
+		return err
+	}
+
+	cloneOpts := &git.CloneOptions{URL: opts.URL, Auth: auth, Depth: opts.Depth, Tags: git.AllTags}
+	if opts.Branch != "" {
+		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(opts.Branch)
+		cloneOpts.SingleBranch = opts.Depth > 0 // match `git clone --depth`, which implies --single-branch
+	}
+	if _, err := git.PlainCloneContext(ctx, opts.Dir, false, cloneOpts); err != nil {
+		return fmt.Errorf("clone of '%s' failed: %w", opts.URL, err)
+	}
+	return nil
+}
+
+func (c *GoGitClient) Fetch(ctx context.Context, dir string, opts FetchOptions) error {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	auth, err := c.auth()
+	if err != nil {
+		return err
+	}
+
+	fetchOpts := &git.FetchOptions{Auth: auth, Prune: opts.Prune, Force: opts.Force, Tags: git.NoTags}
+	if opts.Tags {
+		fetchOpts.Tags = git.AllTags
+	}
+	// A remote that is not a configured name is treated as a URL or path, like `git fetch <url>`.
+	remote := opts.remote()
+	if _, err := repo.Remote(remote); err == nil {
+		fetchOpts.RemoteName = remote
+	} else {
+		fetchOpts.RemoteURL = remote
+	}
+	for _, spec := range opts.RefSpecs {
+		fetchOpts.RefSpecs = append(fetchOpts.RefSpecs, gitconfig.RefSpec(spec))
+	}
+	if opts.Tags && len(fetchOpts.RefSpecs) == 0 {
+		fetchOpts.RefSpecs = []gitconfig.RefSpec{"+refs/tags/*:refs/tags/*"}
+	}
+
+	err = repo.FetchContext(ctx, fetchOpts)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("fetch from '%s' failed: %w", remote, err)
+	}
+	return nil
+}
+
+func (c *GoGitClient) RevParse(ctx context.Context, dir, rev string) (string, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	// ResolveRevision always peels to a commit, so an explicit ^{commit} suffix is redundant.
+	hash, err := repo.ResolveRevision(plumbing.Revision(strings.TrimSuffix(rev, "^{commit}")))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve '%s': %w", rev, err)
+	}
+	return hash.String(), nil
+}
+
+func (c *GoGitClient) IsAncestor(ctx context.Context, dir, ancestor, descendant string) (bool, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return false, err
+	}
+	ancestorCommit, err := repo.CommitObject(plumbing.NewHash(ancestor))
+	if err != nil {
+		return false, fmt.Errorf("failed to load commit '%s': %w", ancestor, err)
+	}
+	descendantCommit, err := repo.CommitObject(plumbing.NewHash(descendant))
+	if err != nil {
+		return false, fmt.Errorf("failed to load commit '%s': %w", descendant, err)
+	}
+	return ancestorCommit.IsAncestor(descendantCommit)
+}
+
+func (c *GoGitClient) FastForward(ctx context.Context, dir, target string) error {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if !head.Name().IsBranch() {
+		return fmt.Errorf("cannot fast-forward a detached HEAD")
+	}
+	targetHash, err := repo.ResolveRevision(plumbing.Revision(target))
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s': %w", target, err)
+	}
+	if *targetHash == head.Hash() {
+		return nil
+	}
+	isAncestor, err := c.IsAncestor(ctx, dir, head.Hash().String(), targetHash.String())
+	if err != nil {
+		return err
+	}
+	if !isAncestor {
+		return fmt.Errorf("'%s' is not a fast-forward of %s", target, head.Name().Short())
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	// A hard reset moves the checked-out branch; callers ensure the working tree is clean first.
+	return worktree.Reset(&git.ResetOptions{Commit: *targetHash, Mode: git.HardReset})
+}
+
+func (c *GoGitClient) Checkout(ctx context.Context, dir, commit string) error {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(commit))
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s': %w", commit, err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return worktree.Checkout(&git.CheckoutOptions{Hash: *hash})
+}
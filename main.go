@@ -8,10 +8,13 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/tmunongo/rivet/config"
 	"github.com/tmunongo/rivet/executor"
+	"github.com/tmunongo/rivet/repository"
 	"github.com/tmunongo/rivet/watcher"
 )
 
@@ -20,6 +23,44 @@ var (
 	build = ""
 )
 
+// pinFlags collects repeated -pin flags of the form <cloneDirName or serviceName>=<ref>.
+type pinFlags map[string]string
+
+func (p pinFlags) String() string {
+	var pins []string
+	for name, ref := range p {
+		pins = append(pins, name+"="+ref)
+	}
+	return strings.Join(pins, ",")
+}
+
+func (p pinFlags) Set(value string) error {
+	name, ref, ok := strings.Cut(value, "=")
+	if !ok || name == "" || ref == "" {
+		return fmt.Errorf("expected <repository>=<ref>, got '%s'", value)
+	}
+	p[name] = ref
+	return nil
+}
+
+// applyPins overrides the configured pin of each repository named by a -pin flag.
+func applyPins(appCfg *config.AppConfig, pins pinFlags) error {
+	for name, ref := range pins {
+		matched := false
+		for i := range appCfg.Repositories {
+			repo := &appCfg.Repositories[i]
+			if repo.CloneDirName == name || repo.ServiceName == name {
+				repo.Pin = ref
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("no configured repository has cloneDirName or serviceName '%s'", name)
+		}
+	}
+	return nil
+}
+
 func main() {
 	// Setup structured logger
 	logHandler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
@@ -49,6 +90,9 @@ func main() {
 
 	configFile := flag.String("config", defaultConfigPath, "Path to the configuration file.")
 	versionFlag := flag.Bool("version", false, "Print Rivet version and exit.")
+	pins := pinFlags{}
+	flag.Var(pins, "pin", "Pin a repository to a commit, tag or branch as <cloneDirName or serviceName>=<ref>. Repeatable.")
+	replayFile := flag.String("replay", "", "Replay a recorded session file against the configuration offline, then exit.")
 	flag.Parse()
 
 	if *versionFlag {
@@ -63,14 +107,37 @@ func main() {
 		slog.Error("Failed to load configuration", "error", err)
 		os.Exit(1)
 	}
+	if err := applyPins(appCfg, pins); err != nil {
+		slog.Error("Invalid -pin flag", "error", err)
+		os.Exit(1)
+	}
 	if len(appCfg.Repositories) == 0 {
 		slog.Info("No repositories configured. Exiting.")
 		os.Exit(0)
 	}
 	slog.Info("Configuration loaded successfully", "repositoriesCount", len(appCfg.Repositories))
 
+	if *replayFile != "" {
+		if err := replay(appCfg, *replayFile); err != nil {
+			slog.Error("Replay failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Create command executor
-	cmdExecutor := executor.NewOSCommandExecutor()
+	osExecutor := executor.NewOSCommandExecutor()
+	osExecutor.KillGracePeriod = time.Duration(appCfg.KillGracePeriodSeconds) * time.Second
+	if len(appCfg.InheritEnv) > 0 {
+		osExecutor.InheritEnv = appCfg.InheritEnv
+	}
+	var cmdExecutor executor.CommandExecutor = osExecutor
+	if appCfg.SessionDir != "" {
+		cmdExecutor = executor.NewRecorder(osExecutor)
+		slog.Info("Recording command sessions", "sessionDir", appCfg.SessionDir)
+	}
+	// Retries wrap the recorder so every attempt ends up in the session.
+	cmdExecutor = executor.NewRetrier(cmdExecutor, retryPolicy(appCfg.Retry))
 
 	// Create and run the watcher
 	appWatcher := watcher.NewWatcher(appCfg, cmdExecutor, slog.Default().WithGroup("watcher"))
@@ -96,6 +163,61 @@ func main() {
 	slog.Info("Rivet CI/CD Tool shut down gracefully.")
 }
 
+// replay runs one check of the repository a session was recorded for against the recorded commands
+// and reports the outcome and any commands that differ from the recording.
+func replay(appCfg *config.AppConfig, path string) error {
+	session, err := executor.LoadSession(path)
+	if err != nil {
+		return err
+	}
+	name := repository.SessionRepository(session)
+	var matches []*config.RepositoryConfig
+	for i := range appCfg.Repositories {
+		if repository.SessionRecordedFor(session, appCfg.Repositories[i]) {
+			matches = append(matches, &appCfg.Repositories[i])
+		}
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no configured repository has cloneDirName '%s' under the basePath from the session", name)
+	}
+	if len(matches) > 1 {
+		return fmt.Errorf("%d configured repositories have cloneDirName '%s' under the basePath from the session", len(matches), name)
+	}
+	repoCfg := matches[0]
+
+	slog.Info("Replaying session", "file", path, "repository", name, "commands", len(session.Invocations))
+	result, err := repository.Replay(context.Background(), *repoCfg, appCfg.MirrorDir, retryPolicy(appCfg.Retry), session, slog.Default().WithGroup("replay"))
+	if err != nil {
+		return err
+	}
+	if result.Run != nil {
+		for _, stage := range result.Run.Stages {
+			slog.Info("Replayed stage", "stage", stage.Name, "status", stage.Status, "retries", len(stage.Retries), "error", stage.Error)
+		}
+	}
+	slog.Info("Replay finished", "error", result.Err, "state", result.Status.State, "deployed", result.Run != nil && result.Run.Succeeded(), "checkRetries", len(result.CheckRetries))
+
+	unexpected, unrun := result.Executor.Unexpected(), result.Executor.Unrun()
+	for _, cmd := range unexpected {
+		slog.Warn("Command not in the recording", "command", cmd.String(), "dir", cmd.Dir)
+	}
+	for _, cmd := range unrun {
+		slog.Warn("Recorded command was not run", "command", cmd.String(), "dir", cmd.Dir)
+	}
+	if len(unexpected) > 0 || len(unrun) > 0 {
+		return fmt.Errorf("replay diverged from the recording: %d unexpected and %d unrun commands", len(unexpected), len(unrun))
+	}
+	return nil
+}
+
+func retryPolicy(cfg config.RetryConfig) executor.RetryPolicy {
+	return executor.RetryPolicy{
+		Attempts:     cfg.Attempts,
+		InitialDelay: time.Duration(cfg.InitialDelaySeconds) * time.Second,
+		MaxDelay:     time.Duration(cfg.MaxDelaySeconds) * time.Second,
+	}
+}
+
 func getDefaultConfigPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -0,0 +1,116 @@
+// Package mirror maintains shared bare mirrors of remote repositories so that several
+// repositories cloned from the same remote fetch its history only once.
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+// Cache hands out one Mirror per remote URL, stored under a common directory.
+type Cache struct {
+	dir      string
+	executor executor.CommandExecutor
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	mirrors map[string]*Mirror
+}
+
+// NewCache creates a mirror cache rooted at dir.
+func NewCache(dir string, exec executor.CommandExecutor, logger *slog.Logger) *Cache {
+	return &Cache{
+		dir:      dir,
+		executor: exec,
+		logger:   logger,
+		mirrors:  make(map[string]*Mirror),
+	}
+}
+
+// Get returns the mirror for the given remote URL, creating its bookkeeping on first use.
+// The mirror itself is cloned lazily by the first call to Sync.
+func (c *Cache) Get(url string) *Mirror {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m, ok := c.mirrors[url]; ok {
+		return m
+	}
+
+	sum := sha256.Sum256([]byte(url))
+	m := &Mirror{
+		URL:      url,
+		Path:     filepath.Join(c.dir, hex.EncodeToString(sum[:8])+".git"),
+		executor: c.executor,
+		logger:   c.logger.With("mirrorUrl", url),
+	}
+	c.mirrors[url] = m
+	return m
+}
+
+// Mirror is a bare `git clone --mirror` of one remote. Clones that use it as a reference
+// borrow its objects through alternates and fetch from it instead of the remote.
+type Mirror struct {
+	URL  string
+	Path string
+
+	executor executor.CommandExecutor
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	lastSync time.Time
+}
+
+// Sync brings the mirror up to date with the remote unless it was already synced within maxAge,
+// so repositories sharing a remote cause one fetch per interval between them. The age counts from
+// when the last sync started, so the time a fetch takes does not push the next one back a whole
+// interval. env and gitArgs are the environment and extra arguments placed before the git
+// subcommand, such as credential options.
+func (m *Mirror) Sync(ctx context.Context, maxAge time.Duration, env []string, gitArgs ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.lastSync.IsZero() && time.Since(m.lastSync) < maxAge {
+		m.logger.Debug("Mirror synced recently, skipping fetch", "lastSync", m.lastSync)
+		return nil
+	}
+
+	var dir string
+	var args []string
+	if _, err := os.Stat(m.Path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(m.Path), 0755); err != nil {
+			return fmt.Errorf("failed to create mirror directory '%s': %w", filepath.Dir(m.Path), err)
+		}
+		m.logger.Info("Creating mirror", "path", m.Path)
+		// Clones borrow objects from the mirror, so it must never garbage-collect them away.
+		dir, args = filepath.Dir(m.Path), []string{"clone", "--mirror", "--config", "gc.auto=0", m.URL, m.Path}
+	} else if err != nil {
+		return fmt.Errorf("failed to check mirror '%s': %w", m.Path, err)
+	} else {
+		m.logger.Debug("Fetching into mirror", "path", m.Path)
+		dir, args = m.Path, []string{"fetch", "--prune", "origin"}
+	}
+
+	started := time.Now()
+	if _, err := m.executor.Execute(ctx, executor.Command{Name: "git", Args: append(gitArgs, args...), Dir: dir, Env: env, Retryable: true}); err != nil {
+		m.logger.Error("Mirror sync failed", "error", err)
+		return fmt.Errorf("mirror sync for '%s' failed: %w", m.URL, err)
+	}
+	m.lastSync = started
+	return nil
+}
+
+// MarkSynced records t as the time of the last sync, so Sync skips fetching until maxAge has passed.
+func (m *Mirror) MarkSynced(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSync = t
+}
@@ -0,0 +1,131 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=rivet", "GIT_AUTHOR_EMAIL=rivet@example.com",
+		"GIT_COMMITTER_NAME=rivet", "GIT_COMMITTER_EMAIL=rivet@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// pushCommit commits to the author clone and pushes it to the remote, returning its SHA.
+func pushCommit(t *testing.T, author, message string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(author, "file.txt"), []byte(message), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, author, "add", "file.txt")
+	runGit(t, author, "commit", "-q", "-m", message)
+	runGit(t, author, "push", "-q", "origin", "main")
+	return runGit(t, author, "rev-parse", "HEAD")
+}
+
+func TestMirrorSync(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	root := t.TempDir()
+	remote, author := filepath.Join(root, "remote.git"), filepath.Join(root, "author")
+	runGit(t, root, "init", "-q", "--bare", "-b", "main", remote)
+	runGit(t, root, "clone", "-q", remote, author)
+	runGit(t, author, "checkout", "-q", "-b", "main")
+	first := pushCommit(t, author, "first")
+
+	cache := NewCache(filepath.Join(root, "mirrors"), executor.NewOSCommandExecutor(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m := cache.Get(remote)
+	if again := cache.Get(remote); again != m {
+		t.Fatal("expected the same mirror for the same remote URL")
+	}
+	if other := cache.Get(filepath.Join(root, "other.git")); other == m || other.Path == m.Path {
+		t.Fatal("expected a separate mirror for another remote URL")
+	}
+
+	// The first sync clones the mirror.
+	ctx := context.Background()
+	if err := m.Sync(ctx, time.Hour, nil); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+	if head := runGit(t, m.Path, "rev-parse", "refs/heads/main"); head != first {
+		t.Fatalf("expected the mirror at %s, got %s", first, head)
+	}
+
+	// Within maxAge a second sync must not fetch, so the new commit stays out of the mirror.
+	second := pushCommit(t, author, "second")
+	if err := m.Sync(ctx, time.Hour, nil); err != nil {
+		t.Fatalf("second sync failed: %v", err)
+	}
+	if head := runGit(t, m.Path, "rev-parse", "refs/heads/main"); head != first {
+		t.Errorf("expected the recently synced mirror not to fetch, got %s", head)
+	}
+
+	// Once maxAge has passed the mirror fetches again.
+	if err := m.Sync(ctx, 0, nil); err != nil {
+		t.Fatalf("third sync failed: %v", err)
+	}
+	if head := runGit(t, m.Path, "rev-parse", "refs/heads/main"); head != second {
+		t.Errorf("expected the mirror to fetch %s, got %s", second, head)
+	}
+}
+
+// slowExecutor delays every command, like a fetch over a slow network.
+type slowExecutor struct {
+	executor.CommandExecutor
+	delay time.Duration
+}
+
+func (e slowExecutor) Execute(ctx context.Context, cmd executor.Command) (*executor.Result, error) {
+	time.Sleep(e.delay)
+	return e.CommandExecutor.Execute(ctx, cmd)
+}
+
+func TestMirrorSyncEveryTick(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	root := t.TempDir()
+	remote, author := filepath.Join(root, "remote.git"), filepath.Join(root, "author")
+	runGit(t, root, "init", "-q", "--bare", "-b", "main", remote)
+	runGit(t, root, "clone", "-q", remote, author)
+	runGit(t, author, "checkout", "-q", "-b", "main")
+	pushCommit(t, author, "first")
+
+	const interval, fetchTime = 300 * time.Millisecond, 100 * time.Millisecond
+	slow := slowExecutor{executor.NewOSCommandExecutor(), fetchTime}
+	m := NewCache(filepath.Join(root, "mirrors"), slow, slog.New(slog.NewTextHandler(io.Discard, nil))).Get(remote)
+	ctx := context.Background()
+	if err := m.Sync(ctx, interval, nil); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+
+	// The next tick comes an interval after the first sync started, which is less than an
+	// interval after it finished. The new commit must still be fetched on that tick.
+	second := pushCommit(t, author, "second")
+	time.Sleep(interval - fetchTime)
+	if err := m.Sync(ctx, interval, nil); err != nil {
+		t.Fatalf("second sync failed: %v", err)
+	}
+	if head := runGit(t, m.Path, "rev-parse", "refs/heads/main"); head != second {
+		t.Errorf("expected the mirror to fetch %s on the next tick, got %s", second, head)
+	}
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/tmunongo/rivet/executor"
+)
+
+// shellQuote quotes a value for the shell snippets git runs for core.sshCommand and credential helpers.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// authArgs returns the `git -c` options that make git use the repository's configured credentials.
+// They apply to the single invocation only, so nothing is written to .git/config, and tokens are
+// read by the credential helper at run time so they never appear in arguments or logs.
+func (r *Repository) authArgs() []string {
+	auth := r.Config.Auth
+	if auth == nil {
+		return nil
+	}
+
+	var args []string
+	if auth.SSHKeyFile != "" || auth.KnownHostsFile != "" {
+		sshCommand := "ssh -o BatchMode=yes"
+		if auth.SSHKeyFile != "" {
+			sshCommand += " -i " + shellQuote(auth.SSHKeyFile) + " -o IdentitiesOnly=yes"
+		}
+		if auth.KnownHostsFile != "" {
+			sshCommand += " -o UserKnownHostsFile=" + shellQuote(auth.KnownHostsFile) + " -o StrictHostKeyChecking=yes"
+		}
+		args = append(args, "-c", "core.sshCommand="+sshCommand)
+	}
+
+	var password string
+	switch {
+	case auth.TokenFile != "":
+		password = `$(cat ` + shellQuote(auth.TokenFile) + `)`
+	case auth.TokenEnv != "":
+		password = `$` + auth.TokenEnv
+	}
+	if password != "" {
+		helper := fmt.Sprintf(`!f() { test "$1" = get && echo username=%s && echo "password=%s"; }; f`, shellQuote(auth.Username), password)
+		// The empty helper clears any helpers configured for the rivet user.
+		args = append(args, "-c", "credential.helper=", "-c", "credential.helper="+helper)
+	}
+	return args
+}
+
+// execGit runs git in dir with the repository's credentials applied, as the runAs user if any.
+func (r *Repository) execGit(ctx context.Context, dir string, args ...string) (*executor.Result, error) {
+	return r.Executor.Execute(ctx, executor.Command{Name: "git", Args: append(r.authArgs(), args...), Dir: dir, Env: r.userEnv(), Credential: r.credential(), Retryable: true})
+}
+
+// gitExecutor runs the commands of the git client through execGit. Credentials and the Executor are
+// looked up on every call, so replacing the repository's Executor moves the git client over too.
+type gitExecutor struct {
+	r *Repository
+}
+
+func (e gitExecutor) Execute(ctx context.Context, cmd executor.Command) (*executor.Result, error) {
+	return e.r.execGit(ctx, cmd.Dir, cmd.Args...)
+}
+
+// goGitAuth builds go-git credentials from the auth configuration: a token for HTTP(S) remotes,
+// otherwise the SSH key. The token is read on every call so rotated tokens are picked up.
+func (r *Repository) goGitAuth() (transport.AuthMethod, error) {
+	auth := r.Config.Auth
+	if auth == nil {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(r.Config.GitURL, "http://") || strings.HasPrefix(r.Config.GitURL, "https://") {
+		var token string
+		switch {
+		case auth.TokenFile != "":
+			data, err := os.ReadFile(auth.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read token file '%s': %w", auth.TokenFile, err)
+			}
+			token = strings.TrimSpace(string(data))
+		case auth.TokenEnv != "":
+			token = os.Getenv(auth.TokenEnv)
+		default:
+			return nil, nil
+		}
+		return &githttp.BasicAuth{Username: auth.Username, Password: token}, nil
+	}
+
+	if auth.SSHKeyFile == "" {
+		return nil, nil
+	}
+	keys, err := gitssh.NewPublicKeysFromFile("git", auth.SSHKeyFile, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SSH key '%s': %w", auth.SSHKeyFile, err)
+	}
+	if auth.KnownHostsFile != "" {
+		callback, err := gitssh.NewKnownHostsCallback(auth.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known hosts '%s': %w", auth.KnownHostsFile, err)
+		}
+		keys.HostKeyCallback = callback
+	}
+	return keys, nil
+}
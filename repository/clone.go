@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/gitclient"
+)
+
+// cloneOptions returns the clone of GitURL into workDir with the configured shallow, partial and sparse options.
+func (r *Repository) cloneOptions(workDir string) gitclient.CloneOptions {
+	opts := gitclient.CloneOptions{URL: r.Config.GitURL, Dir: workDir, Branch: r.Config.Branch}
+	if clone := r.Config.Clone; clone != nil {
+		opts.Depth = clone.Depth
+		opts.Filter = clone.Filter
+		opts.Sparse = len(clone.SparsePaths) > 0
+	}
+	return opts
+}
+
+// branchRefSpec maps the tracked branch to its remote-tracking ref, so fetches from a mirror path
+// update origin/<branch> just like fetches from origin.
+func (r *Repository) branchRefSpec() string {
+	return fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", r.Config.Branch, r.Config.Branch)
+}
+
+// newGitClient creates the git backend selected by gitBackend, carrying the repository's credentials.
+func (r *Repository) newGitClient() gitclient.GitClient {
+	if r.Config.GitBackend == config.GitBackendGo {
+		return gitclient.NewGoGitClient(r.goGitAuth)
+	}
+	return gitclient.NewExecGitClient(gitExecutor{r})
+}
+
+// configureSparseCheckout limits a freshly cloned checkout to the configured sparse paths.
+func (r *Repository) configureSparseCheckout(ctx context.Context) error {
+	if r.Config.Clone == nil || len(r.Config.Clone.SparsePaths) == 0 {
+		return nil
+	}
+	r.logger.Info("Configuring sparse checkout", "paths", r.Config.Clone.SparsePaths)
+	args := append([]string{"sparse-checkout", "set"}, r.Config.Clone.SparsePaths...)
+	if err := r.runGitStep(ctx, args...); err != nil {
+		return fmt.Errorf("failed to configure sparse checkout: %w", err)
+	}
+	return nil
+}
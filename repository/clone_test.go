@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+func TestCloneOptions(t *testing.T) {
+	cases := []struct {
+		name      string
+		cloneYAML string
+		wantArgs  []string // flags between the branch and the URL
+		sparse    []string
+	}{
+		{"full", "", nil, nil},
+		{"shallow", "    clone:\n      depth: 1\n", []string{"--depth", "1"}, nil},
+		{"partial", "    clone:\n      filter: blob:none\n", []string{"--filter=blob:none"}, nil},
+		{"sparse", "    clone:\n      depth: 50\n      filter: blob:none\n      sparsePaths: [services/api, libs]\n",
+			[]string{"--depth", "50", "--filter=blob:none", "--sparse"}, []string{"services/api", "libs"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := executortest.New()
+			r := newTestRepository(t, fake, tc.cloneYAML)
+			r.isInitialised = false
+			workDir, _ := r.getWorkingPath()
+
+			args := append([]string{"clone", "-b", "main"}, tc.wantArgs...)
+			fake.Expect("git", append(args, "https://example.com/app.git", "app")...).InDir(r.Config.BasePath)
+			if tc.sparse != nil {
+				fake.Expect("git", append([]string{"sparse-checkout", "set"}, tc.sparse...)...).InDir(workDir)
+			}
+
+			if err := r.ensureCloned(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fake.Verify(t)
+		})
+	}
+}
+
+func TestGitClientFollowsExecutor(t *testing.T) {
+	r := newTestRepository(t, executortest.New(), "")
+	workDir, _ := r.getWorkingPath()
+	fake := executortest.New()
+	r.Executor = fake
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n").InDir(workDir)
+
+	if commit, err := r.Git.RevParse(context.Background(), workDir, "HEAD"); err != nil || commit != "a" {
+		t.Fatalf("expected the git client to use the replaced executor, got %q, %v", commit, err)
+	}
+	fake.Verify(t)
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Commit message markers recognised by rivet. Matching is case-insensitive.
+var (
+	skipDirectives         = []string{"[skip deploy]", "[deploy skip]", "[rivet skip]", "[skip rivet]"}
+	forceRebuildDirectives = []string{"[rivet force-rebuild]"}
+)
+
+// commitDirectives summarises the markers found in a range of commit messages.
+type commitDirectives struct {
+	// skip is set when every commit in the range asks to be skipped, so a skipped
+	// documentation commit never hides a real change pushed alongside it.
+	skip bool
+	// forceRebuild is set when any commit asks for a build without the layer cache.
+	forceRebuild bool
+}
+
+func containsDirective(message string, directives []string) bool {
+	message = strings.ToLower(message)
+	for _, directive := range directives {
+		if strings.Contains(message, directive) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDirectives(messages []string) commitDirectives {
+	if len(messages) == 0 {
+		return commitDirectives{}
+	}
+	directives := commitDirectives{skip: true}
+	for _, message := range messages {
+		if !containsDirective(message, skipDirectives) {
+			directives.skip = false
+		}
+		if containsDirective(message, forceRebuildDirectives) {
+			directives.forceRebuild = true
+		}
+	}
+	return directives
+}
+
+// commitMessages returns the full messages of the commits reachable from toCommit but not fromCommit.
+func (r *Repository) commitMessages(ctx context.Context, fromCommit, toCommit string) ([]string, error) {
+	workDir, _ := r.getWorkingPath()
+	args := []string{"log", "--format=%B%x00", fmt.Sprintf("%s..%s", fromCommit, toCommit)}
+	result, err := r.execGit(ctx, workDir, args...)
+	if err != nil {
+		r.logger.Error("Git log failed", "error", err)
+		return nil, fmt.Errorf("git log failed: %w", err)
+	}
+
+	var messages []string
+	for _, message := range strings.Split(result.Stdout, "\x00") {
+		if message = strings.TrimSpace(message); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
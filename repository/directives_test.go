@@ -0,0 +1,23 @@
+package repository
+
+import "testing"
+
+func TestParseDirectives(t *testing.T) {
+	cases := []struct {
+		name     string
+		messages []string
+		want     commitDirectives
+	}{
+		{"no commits", nil, commitDirectives{}},
+		{"plain commit", []string{"Fix login redirect"}, commitDirectives{}},
+		{"all skipped", []string{"Update README [skip deploy]", "Typo [Rivet Skip]"}, commitDirectives{skip: true}},
+		{"one real change", []string{"Update README [skip deploy]", "Fix login redirect"}, commitDirectives{}},
+		{"force rebuild", []string{"Bump base image\n\n[rivet force-rebuild]", "Fix login redirect"}, commitDirectives{forceRebuild: true}},
+	}
+
+	for _, c := range cases {
+		if got := parseDirectives(c.messages); got != c.want {
+			t.Errorf("%s: parseDirectives() = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
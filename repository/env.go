@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"os"
+	"sort"
+)
+
+// commandEnv returns the configured env, which is set for every command run for this repository.
+func (r *Repository) commandEnv() []string {
+	names := make([]string, 0, len(r.Config.Env))
+	for name := range r.Config.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, name+"="+r.Config.Env[name])
+	}
+	return env
+}
+
+// gitEnv returns commandEnv plus the token variable git's credential helper reads, which the
+// executor would otherwise withhold along with the rest of rivet's environment. Only git gets the
+// token; tests, hooks and docker never see it.
+func (r *Repository) gitEnv() []string {
+	var env []string
+	if auth := r.Config.Auth; auth != nil && auth.TokenEnv != "" {
+		if token, ok := os.LookupEnv(auth.TokenEnv); ok {
+			env = append(env, auth.TokenEnv+"="+token)
+		}
+	}
+	return append(env, r.commandEnv()...)
+}
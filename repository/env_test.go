@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+func TestTokenOnlyReachesGit(t *testing.T) {
+	t.Setenv("RIVET_TEST_TOKEN", "s3cret")
+	fake := executortest.New()
+	r := newTestRepository(t, fake, `
+    auth:
+      tokenEnv: RIVET_TEST_TOKEN
+    env:
+      COMPOSE_PROJECT_NAME: app
+    test:
+      command: ["sh", "-c", "make test"]
+    preDeploy:
+      - command: ["sh", "-c", "./notify"]
+`)
+	fake.Expect("sh", "-c", "make test")
+	fake.Expect("sh", "-c", "./notify")
+	fake.Expect("git", append(r.authArgs(), "fetch", "origin")...)
+
+	ctx := context.Background()
+	if err := r.RunTests(ctx); err != nil {
+		t.Fatalf("tests failed: %v", err)
+	}
+	if err := r.RunPreDeployHooks(ctx); err != nil {
+		t.Fatalf("hook failed: %v", err)
+	}
+	if _, err := r.execGit(ctx, "", "fetch", "origin"); err != nil {
+		t.Fatalf("git failed: %v", err)
+	}
+	fake.Verify(t)
+
+	for _, cmd := range fake.Calls() {
+		if got, want := slices.Contains(cmd.Env, "RIVET_TEST_TOKEN=s3cret"), cmd.Name == "git"; got != want {
+			t.Errorf("'%s' given the git token: %v, want %v", cmd, got, want)
+		}
+		if !slices.Contains(cmd.Env, "COMPOSE_PROJECT_NAME=app") {
+			t.Errorf("'%s' is missing the configured env", cmd)
+		}
+	}
+}
+
+func TestTestCommandRunsAsRunAsUser(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, `
+    runAs:
+      uid: 1001
+      gid: 1002
+      home: /home/deploy
+    test:
+      command: ["make", "test"]
+`)
+	fake.Expect("make", "test")
+	if err := r.RunTests(context.Background()); err != nil {
+		t.Fatalf("tests failed: %v", err)
+	}
+	fake.Verify(t)
+	cmd := fake.Calls()[0]
+	if cmd.Credential == nil || cmd.Credential.UID != 1001 || cmd.Credential.GID != 1002 || !slices.Contains(cmd.Env, "HOME=/home/deploy") {
+		t.Errorf("expected tests to run as the runAs user, got credential %+v and env %v", cmd.Credential, cmd.Env)
+	}
+}
+
+func TestServiceTestsKeepRivetPrivileges(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, `
+    runAs:
+      uid: 1001
+      gid: 1002
+    test:
+      command: ["go", "test", "./..."]
+      service: tests
+`)
+	workDir, _ := r.getWorkingPath()
+	fake.Expect("docker", "compose", "-f", filepath.Join(workDir, "docker-compose.yml"), "run", "--rm", "tests", "go", "test", "./...")
+	if err := r.RunTests(context.Background()); err != nil {
+		t.Fatalf("tests failed: %v", err)
+	}
+	fake.Verify(t)
+	if cmd := fake.Calls()[0]; cmd.Credential != nil {
+		t.Errorf("expected service tests to run with rivet's privileges, got credential %+v", cmd.Credential)
+	}
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor"
+)
+
+// RunPreDeployHooks runs the configured preDeploy hooks before the new containers take traffic.
+// A failing hook with the "abort" policy stops the deployment.
+func (r *Repository) RunPreDeployHooks(ctx context.Context) error {
+	return r.runHooks(ctx, "preDeploy", r.Config.PreDeploy)
+}
+
+// RunPostDeployHooks runs the configured postDeploy hooks once the deployment is complete.
+// The new containers are already serving at this point, so an "abort" failure marks the
+// run as failed but cannot undo the deployment.
+func (r *Repository) RunPostDeployHooks(ctx context.Context) error {
+	return r.runHooks(ctx, "postDeploy", r.Config.PostDeploy)
+}
+
+func (r *Repository) runHooks(ctx context.Context, phase string, hooks []config.HookConfig) error {
+	if !r.isInitialised {
+		return fmt.Errorf("repository not initialised")
+	}
+	for _, hook := range hooks {
+		err := r.runHook(ctx, hook)
+		if err == nil {
+			continue
+		}
+		if hook.OnFailure == config.HookOnFailureWarn {
+			r.logger.Warn("Hook failed, continuing as configured", "phase", phase, "hook", hook.Name, "error", err)
+			continue
+		}
+		return fmt.Errorf("%s hook '%s' failed: %w", phase, hook.Name, err)
+	}
+	return nil
+}
+
+// hookEnv returns the variables describing the deployment that are passed to every hook.
+func (r *Repository) hookEnv() []string {
+	workDir, _ := r.getWorkingPath()
+	return []string{
+		"RIVET_OLD_COMMIT=" + r.fromCommit,
+		"RIVET_NEW_COMMIT=" + r.toCommit,
+		"RIVET_BRANCH=" + r.Config.Branch,
+		"RIVET_SERVICE=" + r.Config.ServiceName,
+		"RIVET_REPOSITORY_PATH=" + workDir,
+	}
+}
+
+func (r *Repository) runHook(ctx context.Context, hook config.HookConfig) error {
+	workDir, _ := r.getWorkingPath()
+
+	timeout := time.Duration(hook.TimeoutSeconds) * time.Second
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Service hooks get the deployment variables inside the container through `compose run -e`.
+	cmd := executor.Command{Dir: workDir}
+	if hook.Service == "" {
+		// Host hooks run as the runAs user; service hooks need docker and keep rivet's privileges.
+		cmd.Name, cmd.Args, cmd.Env = hook.Command[0], hook.Command[1:], append(r.identityEnv(), r.hookEnv()...)
+		cmd.Credential = r.credential()
+	} else {
+		composeFilePath := r.Config.ComposeFile
+		if !filepath.IsAbs(composeFilePath) {
+			composeFilePath = filepath.Join(workDir, composeFilePath)
+		}
+		cmd.Name = "docker"
+		cmd.Args = []string{"compose", "-f", composeFilePath, "run", "--rm"}
+		for _, kv := range r.hookEnv() {
+			cmd.Args = append(cmd.Args, "-e", kv)
+		}
+		cmd.Args = append(cmd.Args, hook.Service)
+		cmd.Args = append(cmd.Args, hook.Command...)
+	}
+
+	r.logger.Info("Running hook...", "hook", hook.Name, "service", hook.Service, "timeout", timeout)
+	result, err := r.runStreamed(hookCtx, cmd)
+	if err != nil {
+		if result.TimedOut {
+			r.logger.Error("Hook timed out", "hook", hook.Name, "timeout", timeout)
+			return fmt.Errorf("timed out after %s: %w", timeout, err)
+		}
+		r.logger.Error("Hook failed", "hook", hook.Name, "error", err, "exitCode", result.ExitCode)
+		return err
+	}
+	r.logger.Info("Hook completed.", "hook", hook.Name)
+	return nil
+}
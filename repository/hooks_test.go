@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+const hookPolicyYAML = `    preDeploy:
+      - command: ["./migrate.sh"]
+        onFailure: %s
+      - command: ["./seed.sh"]
+`
+
+func TestHookFailurePolicies(t *testing.T) {
+	t.Run("abort", func(t *testing.T) {
+		fake := executortest.New()
+		r := newTestRepository(t, fake, fmt.Sprintf(hookPolicyYAML, config.HookOnFailureAbort))
+		r.fromCommit, r.toCommit = "a", "b"
+		expectPullAndBuild(fake, r)
+		fake.Expect("./migrate.sh").ExitCode(1)
+
+		// Neither the next hook nor the scale-up may run after an aborting hook fails.
+		r.runs.start(r.fromCommit, r.toCommit)
+		err := r.deploy(context.Background())
+		r.runs.finish(err)
+		fake.Verify(t)
+		if err == nil || !strings.Contains(err.Error(), "preDeploy hook './migrate.sh' failed") {
+			t.Fatalf("expected the failing hook to abort the deployment, got %v", err)
+		}
+		run := r.LastRun()
+		if last := run.Stages[len(run.Stages)-1]; last.Name != "preDeploy" || last.Status != StageFailed {
+			t.Errorf("expected the run to stop at a failed preDeploy stage, got %+v", run.Stages)
+		}
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		fake := executortest.New()
+		r := newTestRepository(t, fake, fmt.Sprintf(hookPolicyYAML, config.HookOnFailureWarn))
+		fake.Expect("./migrate.sh").ExitCode(1)
+		fake.Expect("./seed.sh")
+
+		if err := r.RunPreDeployHooks(context.Background()); err != nil {
+			t.Errorf("expected a warning hook failure to be ignored, got %v", err)
+		}
+		fake.Verify(t)
+	})
+}
+
+func TestHooksReceiveCommits(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, `    postDeploy:
+      - command: ["./warm-cache.sh"]
+      - command: ["./smoke-test.sh"]
+        service: tests
+`)
+	workDir, _ := r.getWorkingPath()
+	r.fromCommit, r.toCommit = "a", "b"
+	env := []string{
+		"RIVET_OLD_COMMIT=a",
+		"RIVET_NEW_COMMIT=b",
+		"RIVET_BRANCH=main",
+		"RIVET_SERVICE=web",
+		"RIVET_REPOSITORY_PATH=" + workDir,
+	}
+
+	// Host hooks get the variables in their environment, service hooks through `compose run -e`.
+	fake.Expect("./warm-cache.sh").InDir(workDir)
+	serviceArgs := []string{"compose", "-f", filepath.Join(workDir, "docker-compose.yml"), "run", "--rm"}
+	for _, kv := range env {
+		serviceArgs = append(serviceArgs, "-e", kv)
+	}
+	fake.Expect("docker", append(serviceArgs, "tests", "./smoke-test.sh")...).InDir(workDir)
+
+	if err := r.RunPostDeployHooks(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake.Verify(t)
+	if hostEnv := fake.Calls()[0].Env; !slices.Equal(hostEnv, env) {
+		t.Errorf("expected the host hook environment %q, got %q", env, hostEnv)
+	}
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+// outputSubscribers fans streamed command output out to registered handlers.
+type outputSubscribers struct {
+	mu       sync.Mutex
+	next     int
+	handlers map[int]executor.LineHandler
+}
+
+func (s *outputSubscribers) add(fn executor.LineHandler) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[int]executor.LineHandler)
+	}
+	id := s.next
+	s.next++
+	s.handlers[id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.handlers, id)
+	}
+}
+
+func (s *outputSubscribers) publish(line executor.Line) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, fn := range s.handlers {
+		fn(line)
+	}
+}
+
+// SubscribeOutput registers fn to receive each line of output from the build, test, deploy and
+// hook commands as they run. The returned function removes the subscription.
+// fn is called synchronously and must not block.
+func (r *Repository) SubscribeOutput(fn executor.LineHandler) (unsubscribe func()) {
+	return r.output.add(fn)
+}
+
+// handleLine logs a line of streamed output, records it on the current run and publishes it.
+func (r *Repository) handleLine(command string, line executor.Line) {
+	r.logger.Info(line.Text, "command", command, "stream", string(line.Stream))
+	r.runs.appendOutput(line.Text)
+	r.output.publish(line)
+}
+
+// runStreamed runs a long-running command, streaming its output line by line when the executor
+// supports it. The Result then only holds the retained tails of stdout and stderr; with a plain
+// CommandExecutor the full output is replayed through the same path once the command exits.
+func (r *Repository) runStreamed(ctx context.Context, cmd executor.Command) (*executor.Result, error) {
+	cmd.Env = append(r.commandEnv(), cmd.Env...)
+	onLine := func(line executor.Line) { r.handleLine(cmd.Name, line) }
+	return executor.ExecuteStream(ctx, r.Executor, cmd, onLine)
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tmunongo/rivet/config"
+)
+
+// matchPath reports whether the slash-separated file path matches the glob pattern.
+// Segments are matched with path.Match, and a "**" segment matches zero or more segments.
+func matchPath(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// relevantFiles returns the files that pass the include and exclude patterns of the filter.
+// An empty include list includes every file.
+func relevantFiles(filter *config.PathFilterConfig, files []string) []string {
+	var relevant []string
+	for _, file := range files {
+		if len(filter.Include) > 0 && !matchAny(filter.Include, file) {
+			continue
+		}
+		if matchAny(filter.Exclude, file) {
+			continue
+		}
+		relevant = append(relevant, file)
+	}
+	return relevant
+}
+
+// changedFiles lists the files that differ between two commits.
+func (r *Repository) changedFiles(ctx context.Context, fromCommit, toCommit string) ([]string, error) {
+	workDir, _ := r.getWorkingPath()
+	args := []string{"diff", "--name-only", "--no-renames", fromCommit, toCommit}
+	result, err := r.execGit(ctx, workDir, args...)
+	if err != nil {
+		r.logger.Error("Git diff failed", "error", err)
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
+
+	var files []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
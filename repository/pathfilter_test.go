@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tmunongo/rivet/config"
+)
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"services/api/**", "services/api/main.go", true},
+		{"services/api/**", "services/api/internal/db/db.go", true},
+		{"services/api/**", "services/web/main.go", false},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/guide/setup.md", true},
+		{"*.md", "docs/setup.md", false},
+		{"docker-compose.yml", "docker-compose.yml", true},
+		{"services/*/Dockerfile", "services/api/Dockerfile", true},
+		{"services/*/Dockerfile", "services/api/build/Dockerfile", false},
+	}
+
+	for _, c := range cases {
+		if got := matchPath(c.pattern, c.name); got != c.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestRelevantFiles(t *testing.T) {
+	filter := &config.PathFilterConfig{
+		Include: []string{"services/api/**", "docker-compose.yml"},
+		Exclude: []string{"**/*.md"},
+	}
+	files := []string{"services/api/main.go", "services/api/README.md", "services/web/main.go", "docker-compose.yml"}
+
+	got := relevantFiles(filter, files)
+	want := []string{"services/api/main.go", "docker-compose.yml"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("relevantFiles() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/gitclient"
+)
+
+// Pin holds the repository at the given commit, tag or branch name. Until Unpin is called,
+// Process checks out and deploys exactly that ref and stops following the remote.
+func (r *Repository) Pin(ref string) {
+	r.pinMu.Lock()
+	defer r.pinMu.Unlock()
+	r.pin = strings.TrimSpace(ref)
+	r.logger.Info("Repository pin set", "ref", r.pin)
+}
+
+// Unpin releases a pin so the repository follows its branch or release tags again.
+func (r *Repository) Unpin() {
+	r.pinMu.Lock()
+	defer r.pinMu.Unlock()
+	if r.pin != "" {
+		r.logger.Info("Repository pin removed", "ref", r.pin)
+	}
+	r.pin = ""
+}
+
+// PinnedRef returns the ref the repository is pinned to, or "" if it is not pinned.
+func (r *Repository) PinnedRef() string {
+	r.pinMu.Lock()
+	defer r.pinMu.Unlock()
+	return r.pin
+}
+
+// resolveCommit resolves a ref to a commit SHA, fetching it from origin if it is not known locally.
+func (r *Repository) resolveCommit(ctx context.Context, ref string) (string, error) {
+	if commit, err := r.gitOutput(ctx, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err == nil {
+		return commit, nil
+	}
+	if commit, err := r.gitOutput(ctx, "rev-parse", "--verify", "--quiet", "origin/"+ref+"^{commit}"); err == nil {
+		return commit, nil
+	}
+	fetchArgs := []string{"fetch", r.fetchRemote(), ref}
+	if uploadPack := r.uploadPack(); uploadPack != "" {
+		fetchArgs = append(fetchArgs, "--upload-pack="+uploadPack)
+	}
+	if _, err := r.gitOutput(ctx, fetchArgs...); err != nil {
+		return "", fmt.Errorf("ref '%s' not found locally or on origin: %w", ref, err)
+	}
+	commit, err := r.gitOutput(ctx, "rev-parse", "--verify", "FETCH_HEAD^{commit}")
+	return commit, err
+}
+
+// checkPinned fetches the remote so the pin's lag can be reported, and reports an update when the
+// checkout is not at the pinned commit. Commit directives and path filters do not apply to pins.
+func (r *Repository) checkPinned(ctx context.Context, pin string) (bool, error) {
+	workDir, _ := r.getWorkingPath()
+	fetchOpts := gitclient.FetchOptions{Remote: r.fetchRemote(), Tags: true, Prune: true, Force: true, UploadPack: r.uploadPack()}
+	if r.Config.Branch != "" {
+		fetchOpts.RefSpecs = []string{r.branchRefSpec()}
+	}
+	if err := r.Git.Fetch(ctx, workDir, fetchOpts); err != nil {
+		r.logger.Error("Git fetch failed", "error", err)
+		return false, err
+	}
+
+	pinCommit, err := r.resolveCommit(ctx, pin)
+	if err != nil {
+		return false, err
+	}
+	localCommit, err := r.Git.RevParse(ctx, workDir, "HEAD")
+	if err != nil {
+		return false, err
+	}
+
+	upstream := "origin/" + r.Config.Branch
+	if r.Config.Track == config.TrackTag {
+		tagList, err := r.gitOutput(ctx, "tag", "--list")
+		if err != nil {
+			return false, err
+		}
+		upstream = r.highestTag(splitLines(tagList))
+	}
+	message := fmt.Sprintf("pinned at %s (%s)", pin, pinCommit)
+	if upstream != "" {
+		if lag, err := r.gitOutput(ctx, "rev-list", "--count", pinCommit+".."+upstream); err == nil {
+			message = fmt.Sprintf("pinned at %s (%s), %s commit(s) behind %s", pin, pinCommit, lag, upstream)
+		}
+	}
+	r.status.set(r.logger, StatePinned, message)
+
+	if localCommit == pinCommit {
+		if r.redeployFrom != "" {
+			// Keep the pull on the pin instead of fast-forwarding the branch.
+			r.checkoutTarget = pinCommit
+			return r.redeployCheckout(ctx, localCommit)
+		}
+		r.logger.Info("Checkout is at the pinned commit. Not following remote.", "pin", pin, "commit", pinCommit)
+		return false, nil
+	}
+
+	// A pin may name any commit, so only the pinned commit itself can be required to be signed.
+	verified, err := r.verifySignatures(ctx, "", pinCommit)
+	if err != nil || !verified {
+		return false, err
+	}
+
+	r.logger.Info("Moving checkout to pinned commit", "pin", pin, "localCommit", localCommit, "pinCommit", pinCommit)
+	r.checkoutTarget = pinCommit
+	r.fromCommit, r.toCommit = localCommit, pinCommit
+	r.redeployFrom = ""
+	return true, nil
+}
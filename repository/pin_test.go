@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+func TestPinReportsLagUntilUnpinned(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "")
+	ctx := context.Background()
+
+	// Pinned to v1.0 at p while origin/main has moved three commits on to b.
+	r.Pin("v1.0")
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--tags", "--prune", "--prune-tags", "--force").Times(2)
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "v1.0^{commit}").Returns("p\n").Times(2)
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+	fake.Expect("git", "rev-list", "--count", "p..origin/main").Returns("3\n").Times(2)
+	updates, err := r.CheckForUpdates(ctx)
+	if err != nil || !updates || r.checkoutTarget != "p" {
+		t.Fatalf("expected the checkout to move to the pin, got %v, %v, target %q", updates, err, r.checkoutTarget)
+	}
+	if status := r.Status(); status.State != StatePinned || !strings.Contains(status.Message, "3 commit(s) behind origin/main") {
+		t.Errorf("expected the pin's lag to be reported, got %+v", status)
+	}
+
+	// Once at the pin, the next check only refreshes the lag.
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("p\n")
+	if updates, err := r.CheckForUpdates(ctx); err != nil || updates {
+		t.Fatalf("expected no update at the pinned commit, got %v, %v", updates, err)
+	}
+
+	// Unpinned, the repository follows origin/main again and the pin status clears.
+	r.Unpin()
+	fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("b\trefs/heads/main\n")
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("p\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("b\n")
+	fake.Expect("git", "merge-base", "--is-ancestor", "p", "b")
+	fake.Expect("git", "log", "--format=%B%x00", "p..b")
+	updates, err = r.CheckForUpdates(ctx)
+	fake.Verify(t)
+	if err != nil || !updates || r.fromCommit != "p" || r.toCommit != "b" {
+		t.Fatalf("expected p..b to be deployed after unpinning, got %v, %v, %s..%s", updates, err, r.fromCommit, r.toCommit)
+	}
+	if status := r.Status(); status.State != StateOK {
+		t.Errorf("expected the pin status to clear, got %+v", status)
+	}
+}
+
+func TestFailedPinnedDeploymentIsRetried(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "")
+	workDir, _ := r.getWorkingPath()
+	r.Pin("v1.0")
+
+	// The first check moves the checkout from a to the pin at p, and the build fails.
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--tags", "--prune", "--prune-tags", "--force").Times(3)
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "v1.0^{commit}").Returns("p\n").Times(3)
+	fake.Expect("git", "rev-list", "--count", "p..origin/main").Returns("0\n").Times(3)
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+	fake.Expect("git", "status", "--porcelain", "--untracked-files=all").Times(2)
+	fake.Expect("git", "checkout", "--detach", "p").Times(2)
+	fake.Expect("docker", "compose", "-f", filepath.Join(workDir, "docker-compose.yml"), "build", "--pull", "web").ExitCode(1).Times(2)
+	// Later checks find the checkout already at the pin, which was never deployed.
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("p\n").Times(2)
+
+	ctx := context.Background()
+	if err := r.Process(ctx); err == nil {
+		t.Fatal("expected the pinned deployment to fail")
+	}
+	r.redeployAfter = time.Time{}
+	if err := r.Process(ctx); err == nil {
+		t.Fatal("expected the retried pinned deployment to fail")
+	}
+	if run := r.LastRun(); run == nil || run.FromCommit != "a" || run.ToCommit != "p" {
+		t.Fatalf("expected the recheck to deploy a..p again, got %+v", run)
+	}
+
+	// Once the deployment succeeds nothing is pending.
+	r.redeployFrom = ""
+	if err := r.Process(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake.Verify(t)
+}
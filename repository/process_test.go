@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tmunongo/rivet/executor"
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+func TestFailedDeploymentIsRetried(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "")
+	workDir, _ := r.getWorkingPath()
+	composeFile := filepath.Join(workDir, "docker-compose.yml")
+
+	// First check: origin/main moved from a to b; the pull succeeds and the build fails.
+	fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("b\trefs/heads/main\n").Times(3)
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune").Times(3)
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("b\n").Times(3)
+	fake.Expect("git", "merge-base", "--is-ancestor", "a", "b")
+	fake.Expect("git", "log", "--format=%B%x00", "a..b").AnyTimes()
+	fake.Expect("git", "status", "--porcelain", "--untracked-files=all").AnyTimes()
+	fake.Expect("git", "symbolic-ref", "-q", "HEAD").Returns("refs/heads/main\n").Times(4)
+	fake.Expect("git", "merge", "--ff-only", "origin/main").Times(2)
+	fake.Expect("docker", "compose", "-f", composeFile, "build", "--pull", "web").ExitCode(1).Times(2)
+	// Later checks: the checkout is already at b, which still has to be deployed.
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("b\n").Times(2)
+
+	ctx := context.Background()
+	if err := r.Process(ctx); err == nil {
+		t.Fatal("expected the first deployment to fail")
+	}
+	if wait := time.Until(r.redeployAfter); wait < 30*time.Second {
+		t.Errorf("expected the redeployment to be backed off, got a wait of %s", wait)
+	}
+	first := r.LastRun()
+
+	// The second check falls within the backoff and leaves the failed deployment alone.
+	if err := r.Process(ctx); err != nil {
+		t.Fatalf("expected the redeployment to wait, got %v", err)
+	}
+	if r.LastRun().StartedAt != first.StartedAt {
+		t.Fatal("expected no deployment during the backoff")
+	}
+
+	r.redeployAfter = time.Time{}
+	if err := r.Process(ctx); err == nil {
+		t.Fatal("expected the retried deployment to fail")
+	}
+	fake.Verify(t)
+	if run := r.LastRun(); run == nil || run.FromCommit != "a" || run.ToCommit != "b" {
+		t.Errorf("expected the third check to deploy a..b again, got %+v", run)
+	}
+	if r.redeployFailures != 2 {
+		t.Errorf("expected two failures in a row, got %d", r.redeployFailures)
+	}
+}
+
+func TestPostDeployFailureIsNotRedeployed(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "    postDeploy:\n      - command: [\"./notify.sh\"]\n")
+	r.Config.Timeouts.HealthCheck = 0
+	workDir, _ := r.getWorkingPath()
+	composeFile := filepath.Join(workDir, "docker-compose.yml")
+
+	fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("b\trefs/heads/main\n")
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("b\n")
+	fake.Expect("git", "merge-base", "--is-ancestor", "a", "b")
+	fake.Expect("git", "log", "--format=%B%x00", "a..b")
+	expectPullAndBuild(fake, r)
+	fake.Expect("docker", "compose", "-f", composeFile, "up", "-d", "--no-deps", "--scale", "web=2", "--no-recreate", "web")
+	fake.Expect("docker", "compose", "-f", composeFile, "up", "-d", "--scale", "web=1", "--no-recreate", "web")
+	fake.Expect("./notify.sh").ExitCode(1)
+
+	if err := r.Process(context.Background()); err == nil {
+		t.Fatal("expected the post-deploy hook to fail the run")
+	}
+	fake.Verify(t)
+	if r.redeployFrom != "" || r.redeployFailures != 0 {
+		t.Errorf("expected the live deployment not to be redeployed, pending %q after %d failures", r.redeployFrom, r.redeployFailures)
+	}
+}
+
+func TestCheckRetriesAreRecorded(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "")
+	r.Executor = executor.NewRetrier(fake, executor.RetryPolicy{Attempts: 2})
+
+	// The fetch fails once on a network blip, and the retried check finds nothing new.
+	fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("a\trefs/heads/main\n")
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune").Stderr("fatal: Could not resolve host: example.com\n").ExitCode(128)
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("a\n")
+
+	if err := r.Process(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake.Verify(t)
+	if r.LastRun() != nil {
+		t.Fatal("expected no deployment")
+	}
+	if retries := r.LastCheckRetries(); len(retries) != 1 || retries[0].Attempt != 1 {
+		t.Errorf("expected the retried fetch to be recorded against the check, got %+v", retries)
+	}
+}
+
+func TestUpdateAfterFailedDeploymentStartsAtDeployedCommit(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		yaml   string
+		script func(fake *executortest.Fake, r *Repository)
+	}{
+		{"branch", "", func(fake *executortest.Fake, r *Repository) {
+			fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("c\trefs/heads/main\n")
+			fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("c\n")
+			fake.Expect("git", "merge-base", "--is-ancestor", "b", "c")
+		}},
+		{"tag", "    track: tag\n", func(fake *executortest.Fake, r *Repository) {
+			r.deployedTag = "v1.0.0"
+			fake.Expect("git", "ls-remote", "--tags", "origin").Returns("c\trefs/tags/v1.2.0\n")
+			fake.Expect("git", "fetch", "origin", "--tags", "--prune", "--prune-tags", "--force")
+			fake.Expect("git", "tag", "--list").Returns("v1.0.0\nv1.1.0\nv1.2.0\n")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "v1.2.0^{commit}").Returns("c\n")
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := executortest.New()
+			r := newTestRepository(t, fake, tc.yaml)
+			// The deployment of b over a failed, and only a docs commit has been pushed on top since.
+			// b's commit still has to count, so the range starts at a rather than the checkout.
+			r.redeployFrom = "a"
+			tc.script(fake, r)
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("b\n")
+			fake.Expect("git", "cat-file", "-e", "a^{commit}")
+			fake.Expect("git", "log", "--format=%B%x00", "a..c").Returns("[skip deploy] docs\x00feature\x00")
+
+			updates, err := r.CheckForUpdates(context.Background())
+			fake.Verify(t)
+			if err != nil || !updates || r.fromCommit != "a" || r.toCommit != "c" {
+				t.Fatalf("expected a..c to be deployed, got %v, %v, %s..%s", updates, err, r.fromCommit, r.toCommit)
+			}
+		})
+	}
+}
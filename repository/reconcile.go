@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor"
+)
+
+// sameRemoteURL compares remote URLs, ignoring a trailing slash or ".git" suffix.
+func sameRemoteURL(a, b string) bool {
+	normalise := func(url string) string {
+		url = strings.TrimSuffix(strings.TrimSpace(url), "/")
+		return strings.TrimSuffix(url, ".git")
+	}
+	return normalise(a) == normalise(b)
+}
+
+// gitOutput runs a git command in the clone directory and returns its trimmed stdout.
+func (r *Repository) gitOutput(ctx context.Context, args ...string) (string, error) {
+	workDir, _ := r.getWorkingPath()
+	result, err := r.execGit(ctx, workDir, args...)
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+// reconcileClone verifies that an existing clone's origin URL and checked-out branch match
+// the configuration and applies the configured mismatch policy when they do not.
+func (r *Repository) reconcileClone(ctx context.Context) error {
+	originURL, err := r.gitOutput(ctx, "remote", "get-url", "origin")
+	if err != nil {
+		return err
+	}
+	urlMatches := sameRemoteURL(originURL, r.Config.GitURL)
+
+	// symbolic-ref exits 1 on a detached HEAD, in which case there is no branch to compare.
+	currentBranch, err := r.gitOutput(ctx, "symbolic-ref", "--short", "-q", "HEAD")
+	if err != nil && !executor.IsExitCode(err, 1) {
+		return err
+	}
+	// Tag tracking always leaves HEAD detached, so only the remote matters there.
+	branchMatches := currentBranch == "" || currentBranch == r.Config.Branch || r.Config.Track == config.TrackTag
+
+	if urlMatches && branchMatches {
+		r.status.clear(r.logger, StateConfigMismatch)
+		return nil
+	}
+
+	r.logger.Warn("Existing clone does not match configuration", "policy", r.Config.OnConfigMismatch,
+		"originUrl", originURL, "configuredUrl", r.Config.GitURL, "branch", currentBranch, "configuredBranch", r.Config.Branch)
+
+	if r.Config.OnConfigMismatch == config.MismatchRefuse {
+		message := fmt.Sprintf("clone tracks %s (branch %s) but configuration expects %s (branch %s)", originURL, currentBranch, r.Config.GitURL, r.Config.Branch)
+		r.status.set(r.logger, StateConfigMismatch, message)
+		return fmt.Errorf("%s", message)
+	}
+
+	previousHead, err := r.gitOutput(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if err := r.applyMismatchPolicy(ctx, urlMatches, branchMatches, currentBranch); err != nil {
+		return err
+	}
+	r.status.clear(r.logger, StateConfigMismatch)
+
+	// The running containers were built from the old checkout, so if the checkout moved the next
+	// check must deploy even when the new checkout already matches its remote. A changed URL for
+	// the same repository leaves HEAD where it was and needs no deployment.
+	head, err := r.gitOutput(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if head != previousHead {
+		r.logger.Info("Reconciled checkout moved. It will be redeployed.", "previousCommit", previousHead, "commit", head)
+		r.redeployFrom = previousHead
+	}
+	return nil
+}
+
+// applyMismatchPolicy re-clones the repository or updates the existing clone's origin URL and
+// branch, as the reclone and update mismatch policies say.
+func (r *Repository) applyMismatchPolicy(ctx context.Context, urlMatches, branchMatches bool, currentBranch string) error {
+	if r.Config.OnConfigMismatch == config.MismatchReclone {
+		return r.reclone(ctx)
+	}
+
+	if !urlMatches {
+		r.logger.Info("Updating origin URL", "url", r.Config.GitURL)
+		if err := r.runGitStep(ctx, "remote", "set-url", "origin", r.Config.GitURL); err != nil {
+			return err
+		}
+	}
+	if !branchMatches {
+		r.logger.Info("Switching checkout to configured branch", "from", currentBranch, "to", r.Config.Branch)
+		if err := r.syncMirror(ctx); err != nil {
+			return err
+		}
+		if err := r.fetchBranch(ctx); err != nil {
+			return err
+		}
+		if err := r.runGitStep(ctx, "checkout", "-B", r.Config.Branch, "--track", "origin/"+r.Config.Branch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+// mismatchedClone returns a repository under the given mismatch policy whose clone was made from
+// another remote and has develop checked out.
+func mismatchedClone(t *testing.T, policy string) (*Repository, *executortest.Fake) {
+	t.Helper()
+	return mismatchedCloneOn(t, policy, "develop")
+}
+
+// mismatchedCloneOn is mismatchedClone with the given branch checked out.
+func mismatchedCloneOn(t *testing.T, policy, branch string) (*Repository, *executortest.Fake) {
+	t.Helper()
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "    onConfigMismatch: "+policy+"\n")
+	fake.Expect("git", "remote", "get-url", "origin").Returns("https://example.com/old.git\n")
+	fake.Expect("git", "symbolic-ref", "--short", "-q", "HEAD").Returns(branch + "\n")
+	return r, fake
+}
+
+func TestConfigMismatchUpdate(t *testing.T) {
+	r, fake := mismatchedClone(t, config.MismatchUpdate)
+	fake.Expect("git", "rev-parse", "HEAD").Returns("a\n")
+	fake.Expect("git", "remote", "set-url", "origin", "https://example.com/app.git")
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+	fake.Expect("git", "checkout", "-B", "main", "--track", "origin/main")
+	fake.Expect("git", "rev-parse", "HEAD").Returns("b\n")
+
+	if err := r.reconcileClone(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake.Verify(t)
+	if r.Status().State != StateOK || r.redeployFrom != "a" {
+		t.Errorf("expected the clone to be switched over and redeployed from a, got status %+v and redeployFrom %q", r.Status(), r.redeployFrom)
+	}
+}
+
+func TestConfigMismatchReclone(t *testing.T) {
+	r, fake := mismatchedClone(t, config.MismatchReclone)
+	fake.Expect("git", "rev-parse", "HEAD").Returns("a\n")
+	fake.Expect("git", "clone", "-b", "main", "https://example.com/app.git", "app").InDir(r.Config.BasePath)
+	fake.Expect("git", "rev-parse", "HEAD").Returns("b\n")
+
+	if err := r.reconcileClone(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake.Verify(t)
+	if r.Status().State != StateOK || r.redeployFrom != "a" {
+		t.Errorf("expected a fresh clone redeployed from a, got status %+v and redeployFrom %q", r.Status(), r.redeployFrom)
+	}
+}
+
+func TestConfigMismatchURLOnlyIsNotRedeployed(t *testing.T) {
+	r, fake := mismatchedCloneOn(t, config.MismatchUpdate, "main")
+	fake.Expect("git", "rev-parse", "HEAD").Returns("a\n").Times(2)
+	fake.Expect("git", "remote", "set-url", "origin", "https://example.com/app.git")
+
+	if err := r.reconcileClone(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake.Verify(t)
+	if r.Status().State != StateOK || r.redeployFrom != "" {
+		t.Errorf("expected only the URL to change, got status %+v and redeployFrom %q", r.Status(), r.redeployFrom)
+	}
+}
+
+func TestConfigMismatchRefuse(t *testing.T) {
+	r, fake := mismatchedClone(t, config.MismatchRefuse)
+
+	err := r.reconcileClone(context.Background())
+	fake.Verify(t)
+	if err == nil || r.Status().State != StateConfigMismatch {
+		t.Errorf("expected the mismatched clone to be refused, got %v and status %+v", err, r.Status())
+	}
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/gitclient"
+)
+
+// remoteState returns the remote refs CheckForUpdates follows, as listed by `git ls-remote`.
+// Listing refs only downloads the ref advertisement, so it is much cheaper than a fetch.
+// With a shared mirror the refs are listed from the freshly synced mirror instead.
+func (r *Repository) remoteState(ctx context.Context) (string, error) {
+	// Options must come before the repository; everything after it is a ref pattern.
+	args := []string{"ls-remote"}
+	if uploadPack := r.uploadPack(); uploadPack != "" {
+		args = append(args, "--upload-pack="+uploadPack)
+	}
+	if r.Config.Track == config.TrackTag {
+		args = append(args, "--tags", r.fetchRemote())
+	} else {
+		args = append(args, r.fetchRemote(), "refs/heads/"+r.Config.Branch)
+	}
+	state, err := r.gitOutput(ctx, args...)
+	return state, err
+}
+
+// fetchRemote returns where fetches, pulls and ref listings go: the shared mirror when one is
+// configured, otherwise origin.
+func (r *Repository) fetchRemote() string {
+	if r.Mirror != nil {
+		return r.Mirror.Path
+	}
+	return "origin"
+}
+
+// fetchBranch fetches the tracked branch into origin/<branch>, from the shared mirror if there is one.
+func (r *Repository) fetchBranch(ctx context.Context) error {
+	workDir, _ := r.getWorkingPath()
+	fetchOpts := gitclient.FetchOptions{Remote: r.fetchRemote(), RefSpecs: []string{r.branchRefSpec()}, Prune: true, UploadPack: r.uploadPack()}
+	return r.Git.Fetch(ctx, workDir, fetchOpts)
+}
+
+// syncMirror refreshes the shared mirror at most twice per check interval. It is a no-op without a
+// mirror. The mirror counts as fresh for half an interval only: a tick can arrive a little less than
+// an interval after the previous sync started, and must still fetch rather than wait a whole tick.
+func (r *Repository) syncMirror(ctx context.Context) error {
+	if r.Mirror == nil {
+		return nil
+	}
+	maxAge := time.Duration(r.Config.CheckIntervalSeconds) * time.Second / 2
+	if err := r.Mirror.Sync(ctx, maxAge, r.gitEnv(), r.authArgs()...); err != nil {
+		return fmt.Errorf("failed to sync shared mirror: %w", err)
+	}
+	return nil
+}
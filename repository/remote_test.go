@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/tmunongo/rivet/executor/executortest"
+	"github.com/tmunongo/rivet/mirror"
+)
+
+func TestUnchangedRemoteSkipsFetch(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		yaml   string
+		script func(fake *executortest.Fake, r *Repository)
+	}{
+		{"branch", "", func(fake *executortest.Fake, r *Repository) {
+			fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("abc\trefs/heads/main\n").Times(2)
+			fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("abc\n")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("abc\n")
+		}},
+		{"tag", "    track: tag\n", func(fake *executortest.Fake, r *Repository) {
+			fake.Expect("git", "ls-remote", "--tags", "origin").Returns("abc\trefs/tags/v1.0.0\n").Times(2)
+			fake.Expect("git", "tag", "--list", "--points-at", "HEAD").Returns("v1.0.0\n")
+			fake.Expect("git", "fetch", "origin", "--tags", "--prune", "--prune-tags", "--force")
+			fake.Expect("git", "tag", "--list").Returns("v1.0.0\n")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "v1.0.0^{commit}").Returns("abc\n")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("abc\n")
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := executortest.New()
+			r := newTestRepository(t, fake, tc.yaml)
+			tc.script(fake, r)
+
+			for check := 1; check <= 2; check++ {
+				updates, err := r.CheckForUpdates(context.Background())
+				if err != nil || updates {
+					t.Fatalf("check %d: updates = %v, err = %v", check, updates, err)
+				}
+			}
+			// The fetch is expected once; a second one would be reported as unexpected.
+			fake.Verify(t)
+		})
+	}
+}
+
+func TestRemoteStateTrustsMirrorAsRunAsUser(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "    runAs:\n      uid: 1001\n      gid: 1001\n")
+	r.Mirror = mirror.NewCache(t.TempDir(), fake, slog.New(slog.NewTextHandler(io.Discard, nil))).Get(r.Config.GitURL)
+	// ls-remote takes everything after the repository as a ref pattern, so the option must precede it.
+	fake.Expect("git", "ls-remote", "--upload-pack="+r.uploadPack(), r.Mirror.Path, "refs/heads/main").Returns("abc\trefs/heads/main\n")
+
+	if _, err := r.remoteState(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake.Verify(t)
+}
+
+func TestUnpinAfterUnchangedRemoteFetches(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "")
+	ctx := context.Background()
+
+	// Following main at a, which origin/main never leaves.
+	fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("a\trefs/heads/main\n").Times(2)
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("a\n")
+	if updates, err := r.CheckForUpdates(ctx); err != nil || updates {
+		t.Fatalf("follow: updates = %v, err = %v", updates, err)
+	}
+
+	r.Pin("v1.0")
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--tags", "--prune", "--prune-tags", "--force")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "v1.0^{commit}").Returns("p\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+	fake.Expect("git", "rev-list", "--count", "p..origin/main").Returns("2\n")
+	if updates, err := r.CheckForUpdates(ctx); err != nil || !updates {
+		t.Fatalf("pin: updates = %v, err = %v", updates, err)
+	}
+
+	// The remote is unchanged, but the checkout is at the pin and has to go back to a.
+	r.Unpin()
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("p\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("a\n")
+	fake.Expect("git", "merge-base", "--is-ancestor", "p", "a")
+	fake.Expect("git", "log", "--format=%B%x00", "p..a")
+	updates, err := r.CheckForUpdates(ctx)
+	fake.Verify(t)
+	if err != nil || !updates || r.fromCommit != "p" || r.toCommit != "a" {
+		t.Fatalf("unpin: expected p..a to be deployed, got %v, %v, %s..%s", updates, err, r.fromCommit, r.toCommit)
+	}
+}
+
+func TestUnchangedRemoteRecheckedAfterDivergence(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "")
+
+	// Diverged from origin/main: both checks look at the checkout, which may have been fixed by hand.
+	fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("b\trefs/heads/main\n").Times(2)
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune").Times(2)
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n").Times(2)
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("b\n").Times(2)
+	fake.Expect("git", "merge-base", "--is-ancestor", "a", "b").ExitCode(1).Times(2)
+	for check := 1; check <= 2; check++ {
+		if updates, err := r.CheckForUpdates(context.Background()); err != nil || updates {
+			t.Fatalf("check %d: updates = %v, err = %v", check, updates, err)
+		}
+	}
+	fake.Verify(t)
+	if r.Status().State != StateDiverged {
+		t.Errorf("expected the repository to stay diverged, got %+v", r.Status())
+	}
+}
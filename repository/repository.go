@@ -2,31 +2,78 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tmunongo/rivet/config"
 	"github.com/tmunongo/rivet/executor"
+	"github.com/tmunongo/rivet/gitclient"
+	"github.com/tmunongo/rivet/mirror"
 )
 
+// redeployBackoff spaces out redeployments of a checkout whose deployment keeps failing, e.g. on a
+// broken build, so that it is not rebuilt on every check.
+var redeployBackoff = executor.RetryPolicy{InitialDelay: time.Minute, MaxDelay: time.Hour}
+
 type Repository struct {
 	Config config.RepositoryConfig
 	Executor executor.CommandExecutor
+	Mirror *mirror.Mirror // optional shared mirror of GitURL to clone from and fetch from
+	SessionDir string // optional directory that each run's commands are recorded to; see Process
+	Git gitclient.GitClient
 	logger *slog.Logger
 	workingPath string
 	isInitialised bool
+	replaying bool // set by Replay: commands are answered from a recording and nothing runs as the runAs user
+	runs runRecorder
+	fromCommit string // deployed commit when the pending update was detected
+	toCommit string   // commit the pending update will deploy
+	forceRebuild bool // set by a force-rebuild commit directive for the pending update
+	recovery string   // divergence policy PullChanges must apply instead of a fast-forward
+	redeployFrom string // deployed commit when the checkout moved without a successful deployment; forces the next one
+	redeployFailures int // deployments that failed in a row; see redeployBackoff
+	redeployAfter time.Time // redeployCheckout waits until then after a failed deployment
+	checkoutTarget string // commit PullChanges checks out instead of fast-forwarding the branch
+	lastRemoteState string // remote refs seen by the last completed update check
+	deployedTag string // release tag the checkout was last deployed at in tag mode; see checkForTagUpdates
+	toTag string       // release tag the pending update will deploy
+	status statusTracker
+	pinMu sync.Mutex
+	pin string // ref the repository is held at; empty when following the branch or tags
+	output outputSubscribers
 }
 
 func NewRepository(cfg config.RepositoryConfig, exec executor.CommandExecutor, logger *slog.Logger) *Repository {
-	return &Repository{
+	r := &Repository{
 		Config: cfg,
 		Executor: exec,
 		logger: logger,
+		pin: cfg.Pin,
 	}
+	r.Git = r.newGitClient()
+	return r
+}
+
+// LastRun returns the record of the most recent deployment attempt, or nil if there has been none.
+func (r *Repository) LastRun() *Run {
+	return r.runs.lastRun()
+}
+
+// LastCheckRetries returns the retried attempts the most recent check made outside a deployment
+// stage, such as a fetch that failed once. They are also kept in the Run the check started, if any.
+func (r *Repository) LastCheckRetries() []RetryRecord {
+	return r.runs.checkRetries()
+}
+
+// Status returns the current operational state of the repository.
+func (r *Repository) Status() Status {
+	return r.status.get()
 }
 
 func (r *Repository) getWorkingPath() (string, error) {
@@ -60,8 +107,14 @@ func (r *Repository) ensureCloned(ctx context.Context) error {
 
 	gitDirPath := filepath.Join(workDir, ".git")
 	if _, err := os.Stat(gitDirPath); err == nil {
-		// .git directory exists, assume it's cloned
+		// .git directory exists, make sure it still matches the configuration
 		r.logger.Info("Repository already exists.", "path", workDir)
+		if err := r.ensureOwnership(workDir); err != nil {
+			return err
+		}
+		if err := r.reconcileClone(ctx); err != nil {
+			return fmt.Errorf("failed to reconcile existing clone with configuration: %w", err)
+		}
 		r.isInitialised = true
 		return nil
 	} else if !os.IsNotExist(err) {
@@ -82,21 +135,39 @@ func (r *Repository) ensureCloned(ctx context.Context) error {
 		return fmt.Errorf("failed to check base path '%s': %w", r.Config.BasePath, err)
 	}
 
-	// Command: git clone -b <branch> <url> <cloneDirName>
-	// Executor runs commands *in* a working directory. For clone, the working dir is BasePath.
-	args := []string{"clone", "-b", r.Config.Branch, r.Config.GitURL, r.Config.CloneDirName}
-	stdout, stderr, exitCode, err := r.Executor.Execute(ctx, r.Config.BasePath, "git", args...)
+	// git clone accepts an existing empty directory, which lets a runAs user clone into a base
+	// path it cannot write to.
+	if r.Config.RunAs != nil {
+		if err := os.MkdirAll(workDir, 0755); err != nil {
+			return fmt.Errorf("failed to create clone directory '%s': %w", workDir, err)
+		}
+		if err := r.ensureOwnership(workDir); err != nil {
+			return err
+		}
+	}
 
-	if err != nil {
-		r.logger.Error("Git clone command execution failed", "error", err, "stdout", stdout, "stderr", stderr, "exitCode", exitCode)
-		return fmt.Errorf("git clone execution failed: %w", err)
+	cloneOpts := r.cloneOptions(workDir)
+	if r.Mirror != nil {
+		if err := r.syncMirror(ctx); err != nil {
+			return err
+		}
+		cloneOpts.Reference = r.Mirror.Path
 	}
-	if exitCode != 0 {
-		r.logger.Error("Git clone command returned non-zero exit code", "exitCode", exitCode, "stdout", stdout, "stderr", stderr)
-		return fmt.Errorf("git clone command failed with exit code %d. Stderr: %s", exitCode, stderr)
+	if err := r.Git.Clone(ctx, cloneOpts); err != nil {
+		r.logger.Error("Git clone failed", "error", err)
+		return fmt.Errorf("git clone execution failed: %w", err)
 	}
 
-	r.logger.Info("Git clone successful.", "stdout", stdout)
+	r.logger.Info("Git clone successful.")
+	if err := r.configureSparseCheckout(ctx); err != nil {
+		return err
+	}
+	if err := r.UpdateSubmodules(ctx); err != nil {
+		return err
+	}
+	if err := r.PullLFS(ctx); err != nil {
+		return err
+	}
 	r.isInitialised = true
 	return nil
 }
@@ -105,61 +176,221 @@ func (r *Repository) CheckForUpdates(ctx context.Context) (bool, error) {
 	if !r.isInitialised {
 		return false, fmt.Errorf("repository not initialized, call EnsureCloned first")
 	}
-	workDir, _ := r.getWorkingPath() // Error already checked in EnsureCloned
 	r.logger.Debug("Checking for updates...")
+	if err := r.syncMirror(ctx); err != nil {
+		return false, err
+	}
+	r.recovery = ""
+	r.checkoutTarget = ""
+	r.toTag = ""
+
+	if pin := r.PinnedRef(); pin != "" {
+		// The checkout leaves the branch while pinned, so the first check after unpinning must run in full.
+		r.lastRemoteState = ""
+		return r.checkPinned(ctx, pin)
+	}
+	r.status.clear(r.logger, StatePinned)
+
+	// Ask the remote for its refs first; if they have not moved since the last completed check
+	// there is nothing new to fetch.
+	remoteState, err := r.remoteState(ctx)
+	if err != nil {
+		r.logger.Debug("Could not list remote refs, falling back to fetch", "error", err)
+	} else if r.lastRemoteState != "" && remoteState == r.lastRemoteState && r.redeployFrom == "" {
+		r.logger.Info("No updates found. Remote refs unchanged since last check; skipping fetch.")
+		return false, nil
+	}
 
-	// 1. Fetch updates from remote
+	var updatesFound bool
+	if r.Config.Track == config.TrackTag {
+		updatesFound, err = r.checkForTagUpdates(ctx)
+	} else {
+		updatesFound, err = r.checkForBranchUpdates(ctx)
+	}
+	if err != nil {
+		r.lastRemoteState = ""
+		return false, err
+	}
+	// Only a check that left the repository in good order may be skipped while the remote is
+	// unchanged: a diverged or refused checkout can be fixed locally and has to be looked at again.
+	if r.Status().State != StateOK {
+		remoteState = ""
+	}
+	r.lastRemoteState = remoteState
+	return updatesFound, nil
+}
+
+// checkForBranchUpdates fetches the tracked branch and reports whether the checkout should move to its head.
+func (r *Repository) checkForBranchUpdates(ctx context.Context) (bool, error) {
+	workDir, _ := r.getWorkingPath()
+
+	// 1. Fetch updates from remote. No --depth is passed even for shallow clones: fetching into a
+	// shallow repository without one keeps the new commits connected to the existing history, so
+	// the ancestry check and fast-forward below work the same as on a full clone.
 	r.logger.Debug("Running 'git fetch'...", "branch", r.Config.Branch)
-	fetchArgs := []string{"fetch", "origin", r.Config.Branch, "--prune"}
-	_, stderrFetch, exitCodeFetch, errFetch := r.Executor.Execute(ctx, workDir, "git", fetchArgs...)
-	if errFetch != nil || exitCodeFetch != 0 {
-		r.logger.Error("Git fetch failed", "error", errFetch, "exitCode", exitCodeFetch, "stderr", stderrFetch)
-		return false, fmt.Errorf("git fetch failed (exit %d): %w. Stderr: %s", exitCodeFetch, errFetch, stderrFetch)
+	if err := r.fetchBranch(ctx); err != nil {
+		r.logger.Error("Git fetch failed", "error", err)
+		return false, fmt.Errorf("git fetch failed: %w", err)
 	}
 	r.logger.Debug("'git fetch' successful.")
 
 	// 2. Get local HEAD commit
-	localCommitArgs := []string{"rev-parse", "HEAD"}
-	localCommitOut, stderrLocal, exitCodeLocal, errLocal := r.Executor.Execute(ctx, workDir, "git", localCommitArgs...)
-	if errLocal != nil || exitCodeLocal != 0 {
-		r.logger.Error("Failed to get local HEAD commit", "error", errLocal, "exitCode", exitCodeLocal, "stderr", stderrLocal)
-		return false, fmt.Errorf("failed to get local HEAD (exit %d): %w. Stderr: %s", exitCodeLocal, errLocal, stderrLocal)
+	localCommit, err := r.Git.RevParse(ctx, workDir, "HEAD")
+	if err != nil {
+		r.logger.Error("Failed to get local HEAD commit", "error", err)
+		return false, fmt.Errorf("failed to get local HEAD: %w", err)
 	}
-	localCommit := strings.TrimSpace(localCommitOut)
 	r.logger.Debug("Local commit", "sha", localCommit)
 
 	// 3. Get remote HEAD commit for the tracked branch
 	remoteRef := fmt.Sprintf("origin/%s", r.Config.Branch)
-	remoteCommitArgs := []string{"rev-parse", remoteRef}
-	remoteCommitOut, stderrRemote, exitCodeRemote, errRemote := r.Executor.Execute(ctx, workDir, "git", remoteCommitArgs...)
-	if errRemote != nil || exitCodeRemote != 0 {
-		r.logger.Error("Failed to get remote commit", "remoteRef", remoteRef, "error", errRemote, "exitCode", exitCodeRemote, "stderr", stderrRemote)
-		return false, fmt.Errorf("failed to get remote commit for '%s' (exit %d): %w. Stderr: %s", remoteRef, exitCodeRemote, errRemote, stderrRemote)
+	remoteCommit, err := r.Git.RevParse(ctx, workDir, remoteRef)
+	if err != nil {
+		r.logger.Error("Failed to get remote commit", "remoteRef", remoteRef, "error", err)
+		return false, fmt.Errorf("failed to get remote commit for '%s': %w", remoteRef, err)
 	}
-	remoteCommit := strings.TrimSpace(remoteCommitOut)
 	r.logger.Debug("Remote commit", "sha", remoteCommit, "remoteRef", remoteRef)
 
 	if localCommit == remoteCommit {
+		r.status.clear(r.logger, StateDiverged)
+		r.status.clear(r.logger, StateRefused)
+		if r.redeployFrom != "" {
+			return r.redeployCheckout(ctx, localCommit)
+		}
 		r.logger.Info("No updates found. Local and remote are at the same commit.", "commit", localCommit)
 		return false, nil
 	}
 
-	// 4. Check if local is an ancestor of remote (i.e., behind)
-	ancestorArgs := []string{"merge-base", "--is-ancestor", localCommit, remoteCommit}
-	_, stderrAncestor, exitCodeAncestor, errAncestor := r.Executor.Execute(ctx, workDir, "git", ancestorArgs...)
-	if errAncestor != nil && exitCodeAncestor != 0 && exitCodeAncestor != 1 { // error other than typical non-ancestor exit code 1
-		r.logger.Error("Git merge-base command execution failed", "error", errAncestor, "exitCode", exitCodeAncestor, "stderr", stderrAncestor)
-		return false, fmt.Errorf("git merge-base execution failed (exit %d): %w. Stderr: %s", exitCodeAncestor, errAncestor, stderrAncestor)
+	// 4. Refuse commits without the required signatures before they reach the checkout
+	verified, err := r.verifyUpdate(ctx, localCommit, remoteCommit)
+	if err != nil || !verified {
+		return false, err
 	}
 
-	if exitCodeAncestor == 0 { // localCommit is an ancestor of remoteCommit (and they are different)
-		r.logger.Info("Updates found!", "localCommit", localCommit, "remoteCommit", remoteCommit)
+	// 5. Check if local is an ancestor of remote (i.e., behind)
+	isAncestor, err := r.Git.IsAncestor(ctx, workDir, localCommit, remoteCommit)
+	if err != nil {
+		r.logger.Error("Git ancestry check failed", "error", err)
+		return false, fmt.Errorf("git ancestry check failed: %w", err)
+	}
+
+	if isAncestor { // localCommit is an ancestor of remoteCommit (and they are different)
+		r.status.clear(r.logger, StateDiverged)
+		from := r.updateStart(localCommit)
+		deploy, err := r.shouldDeploy(ctx, from, remoteCommit)
+		if err != nil {
+			return false, err
+		}
+		if !deploy {
+			// Move the checkout forward anyway so the next comparison starts from the remote commit.
+			if err := r.advanceCheckout(ctx); err != nil {
+				return false, fmt.Errorf("failed to fast-forward past skipped commits: %w", err)
+			}
+			r.redeployFrom = ""
+			return false, nil
+		}
+		r.logger.Info("Updates found!", "localCommit", localCommit, "remoteCommit", remoteCommit, "deployedCommit", from)
+		r.fromCommit, r.toCommit = from, remoteCommit
+		r.redeployFrom = ""
 		return true, nil
 	}
-	// exitCodeAncestor == 1 means local is not an ancestor (diverged, or local is ahead).
-	// Other exit codes are actual errors handled above.
-	r.logger.Info("Local commit is not a simple ancestor of remote. Possible divergence or local is ahead. No auto-pull.", "local", localCommit, "remote", remoteCommit)
-	return false, nil
+	// Local is not an ancestor (diverged, or local is ahead).
+	return r.handleDivergence(localCommit, remoteCommit)
+}
+
+// updateStart returns the commit an update of the checkout at localCommit is deployed from: the
+// checkout itself or, while the checkout is pending redeployment, the last deployed commit, so that
+// commits that never reached a deployment count towards directives, path filters and the hooks'
+// RIVET_OLD_COMMIT.
+func (r *Repository) updateStart(localCommit string) string {
+	if r.redeployFrom != "" {
+		return r.redeployFrom
+	}
+	return localCommit
+}
+
+// redeployCheckout prepares a deployment of the checkout at localCommit, which moved without a
+// successful deployment since redeployFrom was deployed. A reconciled checkout was never verified:
+// it may be a different branch or a fresh clone, so its signatures are checked first. After a
+// failed deployment it reports nothing to do until redeployAfter.
+func (r *Repository) redeployCheckout(ctx context.Context, localCommit string) (bool, error) {
+	if wait := time.Until(r.redeployAfter); wait > 0 {
+		r.logger.Info("Last deployment failed. Waiting before redeploying.", "commit", localCommit, "failures", r.redeployFailures, "retryIn", wait.Round(time.Second))
+		return false, nil
+	}
+	verified, err := r.verifyRedeploy(ctx, localCommit)
+	if err != nil || !verified {
+		return false, err
+	}
+	r.logger.Info("Checkout has not been deployed since it last moved. Redeploying.", "previousCommit", r.redeployFrom, "commit", localCommit)
+	r.fromCommit, r.toCommit = r.redeployFrom, localCommit
+	r.redeployFrom = ""
+	return true, nil
+}
+
+// handleDivergence applies the configured divergence policy when the local commit is not an
+// ancestor of the remote one. The reset and reclone policies are carried out by PullChanges.
+func (r *Repository) handleDivergence(localCommit, remoteCommit string) (bool, error) {
+	switch r.Config.OnDivergence {
+	case config.DivergenceReset, config.DivergenceReclone:
+		r.logger.Warn("Local commit is not an ancestor of remote. Recovering as configured.", "policy", r.Config.OnDivergence, "local", localCommit, "remote", remoteCommit)
+		r.status.clear(r.logger, StateDiverged)
+		r.recovery = r.Config.OnDivergence
+		r.fromCommit, r.toCommit = r.updateStart(localCommit), remoteCommit
+		r.redeployFrom = ""
+		return true, nil
+	default:
+		r.logger.Info("Local commit is not a simple ancestor of remote. Possible divergence or local is ahead. No auto-pull.", "local", localCommit, "remote", remoteCommit)
+		r.status.set(r.logger, StateDiverged, fmt.Sprintf("local commit %s is not an ancestor of origin/%s at %s; set onDivergence to 'reset' or 'reclone' or fix the checkout manually", localCommit, r.Config.Branch, remoteCommit))
+		return false, nil
+	}
+}
+
+// shouldDeploy decides whether the commits between fromCommit and toCommit warrant a deployment.
+// Commit message directives are checked first; a force-rebuild directive bypasses the path filters.
+// When fromCommit is a previously deployed commit that a reclone has dropped from the clone, the
+// commits cannot be listed and the update is always deployed.
+func (r *Repository) shouldDeploy(ctx context.Context, fromCommit, toCommit string) (bool, error) {
+	if fromCommit == r.redeployFrom {
+		present, err := r.hasCommit(ctx, fromCommit)
+		if err != nil {
+			return false, err
+		}
+		if !present {
+			r.logger.Warn("Previously deployed commit is not in the clone, deploying without checking directives or path filters", "previousCommit", fromCommit, "commit", toCommit)
+			return true, nil
+		}
+	}
+	messages, err := r.commitMessages(ctx, fromCommit, toCommit)
+	if err != nil {
+		return false, err
+	}
+	directives := parseDirectives(messages)
+	if directives.skip {
+		r.logger.Info("All new commits carry a skip directive. Skipping deployment.", "fromCommit", fromCommit, "toCommit", toCommit, "commits", len(messages))
+		return false, nil
+	}
+	r.forceRebuild = directives.forceRebuild
+	if directives.forceRebuild {
+		r.logger.Info("Force-rebuild directive found. Containers will be built without cache.")
+		return true, nil
+	}
+
+	if r.Config.Paths == nil {
+		return true, nil
+	}
+
+	files, err := r.changedFiles(ctx, fromCommit, toCommit)
+	if err != nil {
+		return false, err
+	}
+	relevant := relevantFiles(r.Config.Paths, files)
+	if len(relevant) == 0 {
+		r.logger.Info("Updates found, but no changed files match the path filters. Skipping deployment.", "fromCommit", fromCommit, "toCommit", toCommit, "changedFiles", len(files))
+		return false, nil
+	}
+	r.logger.Debug("Changed files match the path filters", "matchingFiles", relevant)
+	return true, nil
 }
 
 func (r *Repository) PullChanges(ctx context.Context) error {
@@ -167,15 +398,83 @@ func (r *Repository) PullChanges(ctx context.Context) error {
 		return fmt.Errorf("repository not initialized")
 	}
 	workDir, _ := r.getWorkingPath()
+
+	switch r.recovery {
+	case config.DivergenceReset:
+		return r.resetToRemote(ctx)
+	case config.DivergenceReclone:
+		if err := r.reclone(ctx); err != nil {
+			return err
+		}
+		// The new clone is at the branch head as of now, which may have moved past the commit
+		// CheckForUpdates verified. Deploy the verified commit, not whatever was cloned.
+		head, err := r.Git.RevParse(ctx, workDir, "HEAD")
+		if err != nil {
+			return fmt.Errorf("failed to get HEAD of re-cloned repository: %w", err)
+		}
+		if head != r.toCommit {
+			r.logger.Warn("Branch moved since the update check. Resetting re-cloned checkout to the checked commit.", "head", head, "commit", r.toCommit)
+			return r.runGitStep(ctx, "reset", "--hard", r.toCommit)
+		}
+		return nil
+	}
+
+	if err := r.ensureCleanWorkingTree(ctx); err != nil {
+		return err
+	}
+
+	if r.checkoutTarget != "" {
+		return r.checkoutDetached(ctx, r.checkoutTarget)
+	}
+
+	// Leaving a pin behind a detached HEAD: put the branch back on the checked-out commit so it can
+	// be fast-forwarded. CheckForUpdates has already verified HEAD is an ancestor of the remote.
+	if _, err := r.gitOutput(ctx, "symbolic-ref", "-q", "HEAD"); executor.IsExitCode(err, 1) {
+		r.logger.Info("HEAD is detached. Re-attaching branch before pulling.", "branch", r.Config.Branch)
+		if err := r.runGitStep(ctx, "checkout", "-B", r.Config.Branch); err != nil {
+			return err
+		}
+	}
+
+	// CheckForUpdates has just fetched origin/<branch>, so pulling is a fast-forward to it.
 	r.logger.Info("Pulling changes...", "branch", r.Config.Branch)
+	if err := r.Git.FastForward(ctx, workDir, "origin/"+r.Config.Branch); err != nil {
+		r.logger.Error("Git pull failed", "error", err)
+		return fmt.Errorf("git pull failed: %w", err)
+	}
+	r.logger.Info("'git pull' successful.")
+	return nil
+}
+
+// resetToRemote discards local history and moves the checkout to the remote branch head.
+func (r *Repository) resetToRemote(ctx context.Context) error {
+	workDir, _ := r.getWorkingPath()
+	remoteRef := fmt.Sprintf("origin/%s", r.Config.Branch)
+	r.logger.Warn("Hard resetting checkout to remote branch", "remoteRef", remoteRef)
 
-	args := []string{"pull", "origin", r.Config.Branch, "--ff-only"}
-	stdout, stderr, exitCode, err := r.Executor.Execute(ctx, workDir, "git", args...)
-	if err != nil || exitCode != 0 {
-		r.logger.Error("Git pull failed", "error", err, "exitCode", exitCode, "stdout", stdout, "stderr", stderr)
-		return fmt.Errorf("git pull failed (exit %d): %w. Stderr: %s", exitCode, err, stderr)
+	result, err := r.execGit(ctx, workDir, "reset", "--hard", remoteRef)
+	if err != nil {
+		r.logger.Error("Git reset failed", "error", err)
+		return fmt.Errorf("git reset failed: %w", err)
+	}
+	r.recovery = ""
+	r.logger.Info("'git reset' successful.", "stdout", result.Stdout)
+	return nil
+}
+
+// reclone deletes the clone directory and clones the repository again from scratch.
+func (r *Repository) reclone(ctx context.Context) error {
+	workDir, _ := r.getWorkingPath()
+	r.logger.Warn("Removing clone directory to re-clone repository", "path", workDir)
+
+	if err := os.RemoveAll(workDir); err != nil {
+		return fmt.Errorf("failed to remove clone directory '%s': %w", workDir, err)
+	}
+	r.isInitialised = false
+	if err := r.ensureCloned(ctx); err != nil {
+		return fmt.Errorf("re-clone failed: %w", err)
 	}
-	r.logger.Info("'git pull' successful.", "stdout", stdout)
+	r.recovery = ""
 	return nil
 }
 
@@ -194,16 +493,64 @@ func (r *Repository) BuildContainers(ctx context.Context) error {
 	r.logger.Info("Building containers...", "service", r.Config.ServiceName, "composeFile", composeFilePath)
 	
 	args := []string{"compose", "-f", composeFilePath, "build", "--pull"} // --pull attempts to pull newer base images
+	if r.forceRebuild {
+		args = append(args, "--no-cache")
+	}
 	if r.Config.ServiceName != "" {
 		args = append(args, r.Config.ServiceName)
 	}
 
-	stdout, stderr, exitCode, err := r.Executor.Execute(ctx, workDir, "docker", args...)
-	if err != nil || exitCode != 0 {
-		r.logger.Error("Docker-compose build failed", "error", err, "exitCode", exitCode, "stdout", stdout, "stderr", stderr)
-		return fmt.Errorf("docker compose build failed (exit %d): %w. Stderr: %s", exitCode, err, stderr)
+	result, err := r.runStreamed(ctx, executor.Command{Name: "docker", Args: args, Dir: workDir, Retryable: true})
+	if err != nil {
+		r.logger.Error("Docker-compose build failed", "error", err, "exitCode", result.ExitCode)
+		return fmt.Errorf("docker compose build failed: %w", err)
+	}
+	r.logger.Info("'docker compose build' successful.")
+	return nil
+}
+
+// RunTests runs the configured test command and fails if it does not exit cleanly
+// within the configured timeout. It is a no-op when no test stage is configured.
+func (r *Repository) RunTests(ctx context.Context) error {
+	if !r.isInitialised {
+		return fmt.Errorf("repository not initialised")
+	}
+	if r.Config.Test == nil {
+		return nil
+	}
+	workDir, _ := r.getWorkingPath()
+
+	timeout := time.Duration(r.Config.Test.TimeoutSeconds) * time.Second
+	testCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	command := r.Config.Test.Command
+	cmd := executor.Command{Dir: workDir}
+	if r.Config.Test.Service == "" {
+		// Like host hooks, host tests are user-defined commands and run as the runAs user.
+		cmd.Name, cmd.Args, cmd.Env = command[0], command[1:], r.identityEnv()
+		cmd.Credential = r.credential()
+	} else {
+		// Service tests need docker and keep rivet's privileges.
+		composeFilePath := r.Config.ComposeFile
+		if !filepath.IsAbs(composeFilePath) {
+			composeFilePath = filepath.Join(workDir, composeFilePath)
+		}
+		cmd.Name = "docker"
+		cmd.Args = append([]string{"compose", "-f", composeFilePath, "run", "--rm", r.Config.Test.Service}, command...)
+	}
+	r.logger.Info("Running tests...", "command", strings.Join(command, " "), "service", r.Config.Test.Service, "timeout", timeout)
+
+	result, err := r.runStreamed(testCtx, cmd)
+	if err != nil {
+		if result.TimedOut {
+			r.logger.Error("Tests timed out", "timeout", timeout)
+			return fmt.Errorf("tests timed out after %s: %w", timeout, err)
+		}
+		r.logger.Error("Tests failed", "error", err, "exitCode", result.ExitCode)
+		return fmt.Errorf("tests failed: %w", err)
 	}
-	r.logger.Info("'docker compose build' successful.", "stdout", stdout)
+	r.logger.Info("Tests passed.")
 	return nil
 }
 
@@ -234,15 +581,15 @@ func (r *Repository) DeployContainers(ctx context.Context) error {
 		"--no-recreate", // Important: don't stop existing, just add new
 		serviceName,     // Specify service for --no-recreate to apply correctly
 	}
-	stdoutUp, stderrUp, exitCodeUp, errUp := r.Executor.Execute(ctx, workDir, "docker", upArgs...)
-	if errUp != nil || exitCodeUp != 0 {
-		r.logger.Error("Docker-compose scale up failed", "error", errUp, "exitCode", exitCodeUp, "stdout", stdoutUp, "stderr", stderrUp)
-		return fmt.Errorf("docker compose scale up failed (exit %d): %w. Stderr: %s", exitCodeUp, errUp, stderrUp)
+	resultUp, errUp := r.runStreamed(ctx, executor.Command{Name: "docker", Args: upArgs, Dir: workDir, Retryable: true})
+	if errUp != nil {
+		r.logger.Error("Docker-compose scale up failed", "error", errUp, "exitCode", resultUp.ExitCode)
+		return fmt.Errorf("docker compose scale up failed: %w", errUp)
 	}
-	r.logger.Info("Service scaled up successfully.", "stdout", stdoutUp)
+	r.logger.Info("Service scaled up successfully.")
 
 	// Step 2: Simplified Health Check (wait)
-	healthCheckDelay := 30 * time.Second
+	healthCheckDelay := seconds(r.Config.Timeouts.HealthCheck)
 	r.logger.Info("Waiting for new container to stabilize (simulated health check)...", "duration", healthCheckDelay)
 	select {
 	case <-time.After(healthCheckDelay):
@@ -260,21 +607,40 @@ func (r *Repository) DeployContainers(ctx context.Context) error {
 		"--no-recreate", // Ensure it removes an old one, not the one just started
 		serviceName,
 	}
-	stdoutDown, stderrDown, exitCodeDown, errDown := r.Executor.Execute(ctx, workDir, "docker", downArgs...)
-	if errDown != nil || exitCodeDown != 0 {
-		r.logger.Error("Docker-compose scale down failed", "error", errDown, "exitCode", exitCodeDown, "stdout", stdoutDown, "stderr", stderrDown)
+	resultDown, errDown := r.runStreamed(ctx, executor.Command{Name: "docker", Args: downArgs, Dir: workDir, Retryable: true})
+	if errDown != nil {
+		r.logger.Error("Docker-compose scale down failed", "error", errDown, "exitCode", resultDown.ExitCode)
 		// This is critical, service might be in an inconsistent state
-		return fmt.Errorf("docker compose scale down failed (exit %d): %w. Stderr: %s", exitCodeDown, errDown, stderrDown)
+		return fmt.Errorf("docker compose scale down failed: %w", errDown)
 	}
-	r.logger.Info("Service scaled down successfully. Deployment complete.", "stdout", stdoutDown)
+	r.logger.Info("Service scaled down successfully. Deployment complete.")
 	return nil
 }
 
 // Process checks for updates and, if found, pulls, builds, and deploys.
 // This is the main entry point for periodic checks on a repository.
+// With a SessionDir, the commands of a check that starts a run or fails are saved
+// to a session file there; see Replay.
 func (r *Repository) Process(ctx context.Context) error {
+	if r.SessionDir == "" {
+		return r.process(ctx)
+	}
+	return r.processRecorded(ctx)
+}
+
+func (r *Repository) process(ctx context.Context) error {
+	ctx = executor.WithRetryObserver(ctx, r.onRetry)
+	r.runs.beginCheck()
+	timeouts := r.Config.Timeouts
+
 	// Ensure cloned should be called first if not already initialized.
-	if err := r.ensureCloned(ctx); err != nil {
+	cloneCtx, cancel := withTimeout(ctx, timeouts.Pull)
+	err := r.ensureCloned(cloneCtx)
+	cancel()
+	if err != nil {
+		if timedOut(ctx, err) {
+			err = fmt.Errorf("timed out after %s: %w", seconds(timeouts.Pull), err)
+		}
 		r.logger.Error("Failed to ensure repository is cloned/initialized", "error", err)
 		return fmt.Errorf("failed to initialize repository: %w", err)
 	}
@@ -282,9 +648,27 @@ func (r *Repository) Process(ctx context.Context) error {
 	if ctx.Err() != nil { r.logger.Info("Context cancelled after ensureCloned"); return ctx.Err() }
 
 
+	// A blocked repository was already reported when it became dirty; stay quiet until it is cleaned up.
+	if r.Status().State == StateDirty {
+		changes, err := r.localModifications(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to re-check working tree: %w", err)
+		}
+		if len(changes) > 0 {
+			r.logger.Debug("Repository is blocked by local modifications", "changes", len(changes))
+			return nil
+		}
+		r.status.clear(r.logger, StateDirty)
+	}
+
 	r.logger.Info("Processing repository")
-	updatesFound, err := r.CheckForUpdates(ctx)
+	checkCtx, cancel := withTimeout(ctx, timeouts.Fetch)
+	updatesFound, err := r.CheckForUpdates(checkCtx)
+	cancel()
 	if err != nil {
+		if timedOut(ctx, err) {
+			err = fmt.Errorf("timed out after %s: %w", seconds(timeouts.Fetch), err)
+		}
 		r.logger.Error("Failed to check for updates", "error", err)
 		return fmt.Errorf("update check failed: %w", err)
 	}
@@ -297,27 +681,81 @@ func (r *Repository) Process(ctx context.Context) error {
 	}
 
 	r.logger.Info("Updates detected. Starting deployment process...")
-	if err := r.PullChanges(ctx); err != nil {
-		r.logger.Error("Failed to pull changes", "error", err)
-		return fmt.Errorf("pull changes failed: %w", err)
-	}
-    if ctx.Err() != nil { r.logger.Info("Context cancelled after PullChanges"); return ctx.Err() }
-
-
-	if err := r.BuildContainers(ctx); err != nil {
-		r.logger.Error("Failed to build containers", "error", err)
-		return fmt.Errorf("build containers failed: %w", err)
+	r.runs.start(r.fromCommit, r.toCommit)
+	err = r.deploy(ctx)
+	r.runs.finish(err)
+	// A failure after the deploy stage, in a post-deploy hook, leaves the new commit running;
+	// deploying it again would not help.
+	live := err == nil || r.LastRun().stageSucceeded("deploy")
+	if live {
+		if r.toTag != "" {
+			r.deployedTag = r.toTag
+		}
+		r.redeployFailures = 0
+	} else {
+		// The checkout may already be at the new commit, so the next check would find nothing to
+		// do. Make it run in full and deploy again from the last deployed commit, backing off
+		// while the same deployment keeps failing.
+		r.lastRemoteState = ""
+		r.redeployFrom = r.fromCommit
+		r.redeployFailures++
+		r.redeployAfter = time.Now().Add(redeployBackoff.Delay(r.redeployFailures))
+	}
+	r.toTag = ""
+	r.forceRebuild = false
+	r.recovery = ""
+	r.checkoutTarget = ""
+	if err != nil {
+		return err
 	}
-    if ctx.Err() != nil { r.logger.Info("Context cancelled after BuildContainers"); return ctx.Err() }
 
+	r.logger.Info("Repository processed and deployed successfully.")
+	return nil
+}
 
-	if err := r.DeployContainers(ctx); err != nil {
-		r.logger.Error("Failed to deploy containers", "error", err)
-		return fmt.Errorf("deploy containers failed: %w", err)
+// deploy runs the enabled stages in order, stopping at the first failure.
+func (r *Repository) deploy(ctx context.Context) error {
+	// Stages with no timeout here bound their commands themselves: tests and each hook.
+	timeouts := r.Config.Timeouts
+	stages := []struct {
+		name    string
+		enabled bool
+		run     func(context.Context) error
+		desc    string
+		timeout int
+	}{
+		{"pull", true, r.PullChanges, "pull changes", timeouts.Pull},
+		{"submodules", r.Config.Submodules, r.UpdateSubmodules, "submodule update", timeouts.Pull},
+		{"lfs", r.Config.LFS, r.PullLFS, "LFS pull", timeouts.Pull},
+		{"build", true, r.BuildContainers, "build containers", timeouts.Build},
+		{"test", r.Config.Test != nil, r.RunTests, "test stage", 0},
+		{"preDeploy", len(r.Config.PreDeploy) > 0, r.RunPreDeployHooks, "pre-deploy hooks", 0},
+		{"deploy", true, r.DeployContainers, "deploy containers", timeouts.Deploy},
+		{"postDeploy", len(r.Config.PostDeploy) > 0, r.RunPostDeployHooks, "post-deploy hooks", 0},
+	}
+
+	for _, stage := range stages {
+		if !stage.enabled {
+			continue
+		}
+		r.runs.beginStage(stage.name)
+		stageCtx, cancel := withTimeout(ctx, stage.timeout)
+		err := stage.run(stageCtx)
+		cancel()
+		stageTimedOut := timedOut(ctx, err)
+		var cmdTimeout *executor.TimeoutError
+		if stageTimedOut && stage.timeout > 0 && !errors.As(err, &cmdTimeout) {
+			err = fmt.Errorf("timed out after %s: %w", seconds(stage.timeout), err)
+		}
+		r.runs.endStage(err, stageTimedOut)
+		if err != nil {
+			r.logger.Error("Deployment stage failed", "stage", stage.name, "error", err)
+			return fmt.Errorf("%s failed: %w", stage.desc, err)
+		}
+		if ctx.Err() != nil {
+			r.logger.Info("Context cancelled after " + stage.name + " stage")
+			return ctx.Err()
+		}
 	}
-    if ctx.Err() != nil { r.logger.Info("Context cancelled after DeployContainers"); return ctx.Err() }
-
-
-	r.logger.Info("Repository processed and deployed successfully.")
 	return nil
-}
\ No newline at end of file
+}
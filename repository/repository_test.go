@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+// newTestRepository loads a single repository from YAML through config.LoadConfig, so defaults
+// and policies are applied as in production, and wires it to fake. The base path is a temporary
+// directory and the repository starts out initialised.
+func newTestRepository(t *testing.T, fake *executortest.Fake, repoYAML string) *Repository {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rivet.yaml")
+	contents := "repositories:\n  - basePath: " + dir + "\n    gitUrl: https://example.com/app.git\n    cloneDirName: app\n    branch: main\n    serviceName: web\n" + repoYAML
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	r := NewRepository(cfg.Repositories[0], fake, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r.isInitialised = true
+	return r
+}
+
+func TestDivergencePolicies(t *testing.T) {
+	cases := []struct {
+		policy  string
+		recover func(fake *executortest.Fake, r *Repository) // scripts how PullChanges recovers; nil when refused
+	}{
+		{config.DivergenceRefuse, nil},
+		{config.DivergenceReset, func(fake *executortest.Fake, r *Repository) {
+			fake.Expect("git", "reset", "--hard", "origin/main")
+		}},
+		{config.DivergenceReclone, func(fake *executortest.Fake, r *Repository) {
+			fake.Expect("git", "clone", "-b", "main", "https://example.com/app.git", "app").InDir(r.Config.BasePath)
+			// origin/main has moved on to c since the check; the verified b is deployed instead.
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("c\n")
+			fake.Expect("git", "reset", "--hard", "b")
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			fake := executortest.New()
+			r := newTestRepository(t, fake, "    onDivergence: "+tc.policy+"\n")
+			workDir, _ := r.getWorkingPath()
+			if err := os.MkdirAll(workDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			stale := filepath.Join(workDir, "stale")
+			if err := os.WriteFile(stale, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// The local commit a has been rewritten away on origin/main, which is now at b.
+			fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("b\trefs/heads/main\n")
+			fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("b\n")
+			fake.Expect("git", "merge-base", "--is-ancestor", "a", "b").ExitCode(1)
+
+			ctx := context.Background()
+			updates, err := r.CheckForUpdates(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.recover == nil {
+				fake.Verify(t)
+				if updates || r.Status().State != StateDiverged {
+					t.Errorf("expected the divergence to be refused, got updates %v and status %+v", updates, r.Status())
+				}
+				return
+			}
+			if !updates || r.fromCommit != "a" || r.toCommit != "b" {
+				t.Fatalf("expected a..b to be deployed, got updates %v, %s..%s", updates, r.fromCommit, r.toCommit)
+			}
+			tc.recover(fake, r)
+			if err := r.PullChanges(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fake.Verify(t)
+			if _, err := os.Stat(stale); (tc.policy == config.DivergenceReclone) != os.IsNotExist(err) {
+				t.Errorf("expected the clone directory to be removed only when recloning, stat: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"github.com/tmunongo/rivet/executor"
+)
+
+// onRetry reports a transient command failure that the executor is about to retry and records it
+// against the current stage, or against the check outside a stage.
+func (r *Repository) onRetry(retry executor.Retry) {
+	r.logger.Warn("Command failed transiently, retrying", "command", retry.Command.String(), "attempt", retry.Attempt, "delay", retry.Delay, "error", retry.Err)
+	r.runs.addRetry(RetryRecord{
+		Command: retry.Command.String(),
+		Attempt: retry.Attempt,
+		Error:   retry.Err.Error(),
+		Delay:   retry.Delay,
+	})
+}
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+// maxStageOutput bounds the output kept for each stage; older output is dropped first.
+const maxStageOutput = executor.DefaultTailBytes
+
+// StageStatus describes how a single stage of a run finished.
+type StageStatus string
+
+const (
+	StageSucceeded StageStatus = "succeeded"
+	StageFailed    StageStatus = "failed"
+	StageTimedOut  StageStatus = "timed-out"
+)
+
+// StageRecord captures the outcome and output of one stage of a run.
+type StageRecord struct {
+	Name      string
+	Status    StageStatus
+	StartedAt time.Time
+	Duration  time.Duration
+	Output    string
+	Error     string
+	Retries   []RetryRecord // failed attempts of the stage's commands that were retried
+}
+
+// RetryRecord describes one failed attempt of a command that was then retried.
+type RetryRecord struct {
+	Command string
+	Attempt int // the attempt that failed, counting from 1
+	Error   string
+	Delay   time.Duration // wait before the next attempt
+}
+
+// Run records a single deployment attempt made by Process.
+type Run struct {
+	FromCommit string
+	ToCommit   string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Stages     []StageRecord
+	Error      string
+	// CheckRetries are the retried attempts of the check that found the update, e.g. a fetch that
+	// failed once, made before the first stage began.
+	CheckRetries []RetryRecord
+}
+
+// Succeeded reports whether every stage of the run completed without error.
+func (run *Run) Succeeded() bool {
+	return run.Error == ""
+}
+
+// stageSucceeded reports whether the named stage ran and completed without error.
+func (run *Run) stageSucceeded(name string) bool {
+	for _, stage := range run.Stages {
+		if stage.Name == name {
+			return stage.Status == StageSucceeded
+		}
+	}
+	return false
+}
+
+// runRecorder tracks the run in progress, the last finished run and the retries of the latest check.
+type runRecorder struct {
+	mu      sync.Mutex
+	current *Run
+	output  strings.Builder
+	last    *Run
+	check   []RetryRecord
+}
+
+// beginCheck forgets the retries of the previous check.
+func (rr *runRecorder) beginCheck() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.check = nil
+}
+
+func (rr *runRecorder) start(fromCommit, toCommit string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.current = &Run{
+		FromCommit:   fromCommit,
+		ToCommit:     toCommit,
+		StartedAt:    time.Now(),
+		CheckRetries: append([]RetryRecord(nil), rr.check...),
+	}
+}
+
+func (rr *runRecorder) beginStage(name string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.current == nil {
+		return
+	}
+	rr.output.Reset()
+	rr.current.Stages = append(rr.current.Stages, StageRecord{Name: name, StartedAt: time.Now()})
+}
+
+// appendOutput adds command output to the stage in progress. It is a no-op outside a run.
+func (rr *runRecorder) appendOutput(output string) {
+	if output == "" {
+		return
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.current == nil || len(rr.current.Stages) == 0 {
+		return
+	}
+	rr.output.WriteString(output)
+	if !strings.HasSuffix(output, "\n") {
+		rr.output.WriteString("\n")
+	}
+	if over := rr.output.Len() - maxStageOutput; over > 0 {
+		kept := rr.output.String()[over:]
+		rr.output.Reset()
+		rr.output.WriteString(kept)
+	}
+}
+
+// addRetry records a retried attempt against the stage in progress, or against the check when no
+// stage is running.
+func (rr *runRecorder) addRetry(retry RetryRecord) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.current == nil || len(rr.current.Stages) == 0 {
+		rr.check = append(rr.check, retry)
+		if rr.current != nil {
+			rr.current.CheckRetries = append(rr.current.CheckRetries, retry)
+		}
+		return
+	}
+	stage := &rr.current.Stages[len(rr.current.Stages)-1]
+	stage.Retries = append(stage.Retries, retry)
+}
+
+// endStage completes the stage in progress. A failed stage is recorded as timed out when timedOut is set.
+func (rr *runRecorder) endStage(err error, timedOut bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.current == nil || len(rr.current.Stages) == 0 {
+		return
+	}
+	stage := &rr.current.Stages[len(rr.current.Stages)-1]
+	stage.Duration = time.Since(stage.StartedAt)
+	stage.Output = rr.output.String()
+	stage.Status = StageSucceeded
+	if err != nil {
+		stage.Status = StageFailed
+		if timedOut {
+			stage.Status = StageTimedOut
+		}
+		stage.Error = err.Error()
+	}
+	rr.output.Reset()
+}
+
+func (rr *runRecorder) finish(err error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.current == nil {
+		return
+	}
+	rr.current.FinishedAt = time.Now()
+	if err != nil {
+		rr.current.Error = err.Error()
+	}
+	rr.last = rr.current
+	rr.current = nil
+}
+
+// lastRun returns a copy of the most recently finished run, or nil if none has finished yet.
+func (rr *runRecorder) lastRun() *Run {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.last == nil {
+		return nil
+	}
+	run := *rr.last
+	run.CheckRetries = append([]RetryRecord(nil), rr.last.CheckRetries...)
+	run.Stages = append([]StageRecord(nil), rr.last.Stages...)
+	for i := range run.Stages {
+		run.Stages[i].Retries = append([]RetryRecord(nil), run.Stages[i].Retries...)
+	}
+	return &run
+}
+
+// checkRetries returns a copy of the retries recorded outside a stage by the check in progress, or
+// by the latest one.
+func (rr *runRecorder) checkRetries() []RetryRecord {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return append([]RetryRecord(nil), rr.check...)
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+// expectPull scripts a successful pull stage.
+func expectPull(fake *executortest.Fake) {
+	fake.Expect("git", "status", "--porcelain", "--untracked-files=all")
+	fake.Expect("git", "symbolic-ref", "-q", "HEAD").Returns("refs/heads/main\n").Times(2)
+	fake.Expect("git", "merge", "--ff-only", "origin/main")
+}
+
+// expectPullAndBuild scripts a successful pull and build stage.
+func expectPullAndBuild(fake *executortest.Fake, r *Repository) {
+	workDir, _ := r.getWorkingPath()
+	expectPull(fake)
+	fake.Expect("docker", "compose", "-f", filepath.Join(workDir, "docker-compose.yml"), "build", "--pull", "web")
+}
+
+func TestFailedTestStageAbortsDeployment(t *testing.T) {
+	cases := []struct {
+		name       string
+		script     func(*executortest.Expectation)
+		wantErr    string
+		wantStatus StageStatus
+		wantOutput string
+	}{
+		{"failing", func(e *executortest.Expectation) { e.Stderr("--- FAIL: TestCheckout\n").ExitCode(1) }, "tests failed", StageFailed, "--- FAIL: TestCheckout"},
+		{"timed out", func(e *executortest.Expectation) { e.Hangs() }, "tests timed out after 1s", StageTimedOut, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := executortest.New()
+			r := newTestRepository(t, fake, "    test:\n      command: [\"docker\", \"compose\", \"run\", \"--rm\", \"tests\"]\n      timeoutSeconds: 1\n")
+			expectPullAndBuild(fake, r)
+			tc.script(fake.Expect("docker", "compose", "run", "--rm", "tests"))
+
+			// Nothing may be scaled up once the tests have failed.
+			r.runs.start("a", "b")
+			err := r.deploy(context.Background())
+			r.runs.finish(err)
+			fake.Verify(t)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected the deployment to fail with %q, got %v", tc.wantErr, err)
+			}
+
+			run := r.LastRun()
+			if run == nil || run.Succeeded() {
+				t.Fatalf("expected a failed run, got %+v", run)
+			}
+			if len(run.Stages) != 3 || run.Stages[2].Name != "test" || run.Stages[2].Status != tc.wantStatus {
+				t.Fatalf("expected the run to stop at a %s test stage, got %+v", tc.wantStatus, run.Stages)
+			}
+			if !strings.Contains(run.Stages[2].Output, tc.wantOutput) {
+				t.Errorf("expected the test output in the run record, got %q", run.Stages[2].Output)
+			}
+		})
+	}
+}
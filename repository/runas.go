@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tmunongo/rivet/executor"
+)
+
+// credential returns the user git, tests and host hooks run as, or nil to keep rivet's own privileges.
+func (r *Repository) credential() *executor.Credential {
+	runAs := r.Config.RunAs
+	if runAs == nil {
+		return nil
+	}
+	return &executor.Credential{UID: uint32(*runAs.UID), GID: uint32(*runAs.GID)}
+}
+
+// userEnv returns gitEnv plus the identity of the runAs user: the environment of git commands
+// run for the repository.
+func (r *Repository) userEnv() []string {
+	return append(r.gitEnv(), r.identityEnv()...)
+}
+
+// identityEnv describes the runAs user, so git and ssh read that user's configuration rather
+// than rivet's.
+func (r *Repository) identityEnv() []string {
+	runAs := r.Config.RunAs
+	if runAs == nil {
+		return nil
+	}
+	var env []string
+	if runAs.Home != "" {
+		env = append(env, "HOME="+runAs.Home)
+	}
+	if runAs.User != "" {
+		env = append(env, "USER="+runAs.User, "LOGNAME="+runAs.User)
+	}
+	return env
+}
+
+// uploadPack returns the upload-pack command for fetching from the shared mirror as the runAs
+// user. The mirror belongs to rivet, so git's ownership check has to be told to trust it, and
+// only the upload-pack command line reaches the process that reads a local repository.
+func (r *Repository) uploadPack() string {
+	if r.Config.RunAs == nil || r.Mirror == nil {
+		return ""
+	}
+	return "git -c safe.directory=" + shellQuote(r.Mirror.Path) + " upload-pack"
+}
+
+// ensureOwnership hands the clone directory and everything in it to the runAs user, so git
+// running as that user can update a clone created by rivet itself or left by an earlier setup.
+// A replay leaves its scratch clone alone, which also lets it run without root.
+func (r *Repository) ensureOwnership(workDir string) error {
+	runAs := r.Config.RunAs
+	if runAs == nil || r.replaying {
+		return nil
+	}
+	err := filepath.WalkDir(workDir, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, *runAs.UID, *runAs.GID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to give '%s' to uid %d: %w", workDir, *runAs.UID, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version. Build metadata is ignored for ordering.
+type semver struct {
+	major, minor, patch int
+	prerelease          []string
+}
+
+// parseSemver parses tags such as "v1.4.2", "1.4.2-rc.1" or "v2.0". Missing minor and
+// patch components are treated as zero.
+func parseSemver(tag string) (semver, bool) {
+	version := strings.TrimPrefix(tag, "v")
+	if i := strings.IndexByte(version, '+'); i >= 0 {
+		version = version[:i]
+	}
+	var v semver
+	if i := strings.IndexByte(version, '-'); i >= 0 {
+		if i == len(version)-1 {
+			return semver{}, false
+		}
+		v.prerelease = strings.Split(version[i+1:], ".")
+		version = version[:i]
+	}
+
+	parts := strings.Split(version, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return semver{}, false
+	}
+	numbers := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		*numbers[i] = n
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 following semantic versioning precedence rules.
+func (v semver) compare(other semver) int {
+	for _, pair := range [][2]int{{v.major, other.major}, {v.minor, other.minor}, {v.patch, other.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A version without a prerelease has higher precedence than one with a prerelease.
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+		aNum, aErr := strconv.Atoi(a)
+		bNum, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		case aErr == nil:
+			return -1 // numeric identifiers sort before alphanumeric ones
+		case bErr == nil:
+			return 1
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch {
+	case len(v.prerelease) < len(other.prerelease):
+		return -1
+	case len(v.prerelease) > len(other.prerelease):
+		return 1
+	}
+	return 0
+}
+
+// compareTags orders tags by semantic version. Tags that are not semantic versions sort below
+// those that are, and are ordered lexically among themselves.
+func compareTags(a, b string) int {
+	av, aOK := parseSemver(a)
+	bv, bOK := parseSemver(b)
+	switch {
+	case aOK && bOK:
+		return av.compare(bv)
+	case aOK:
+		return 1
+	case bOK:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
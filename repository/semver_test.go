@@ -0,0 +1,27 @@
+package repository
+
+import "testing"
+
+func TestCompareTags(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "v1.10.0", -1},
+		{"v2.0", "v1.9.9", 1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", -1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-1", "v1.0.0-alpha", -1},
+		{"v1.0.0+build.5", "v1.0.0", 0},
+		{"release-2024", "v0.0.1", -1},
+		{"release-2024", "release-2023", 1},
+	}
+
+	for _, c := range cases {
+		if got := compareTags(c.a, c.b); got != c.want {
+			t.Errorf("compareTags(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor"
+	"github.com/tmunongo/rivet/mirror"
+)
+
+// Session meta keys describing the repository state a recorded check started from.
+const (
+	metaRepository      = "repository"
+	metaBasePath        = "basePath"
+	metaMirrorDir       = "mirrorDir"
+	metaInitialised     = "initialised"
+	metaCloneExists     = "cloneExists"
+	metaLastRemoteState = "lastRemoteState"
+	metaRedeployFrom    = "redeployFrom"
+	metaDeployedTag     = "deployedTag"
+	metaPin             = "pin"
+	metaState           = "state"
+	metaStateMessage    = "stateMessage"
+)
+
+// processRecorded runs process with its commands recorded into a session, which is saved when
+// the check started a run or failed. Quiet checks are not worth keeping. Recording only sees
+// commands run through an executor.Recorder.
+func (r *Repository) processRecorded(ctx context.Context) error {
+	session := &executor.Session{StartedAt: time.Now().UTC(), Meta: r.sessionMeta()}
+	before := r.LastRun()
+	err := r.process(executor.WithSession(ctx, session))
+
+	run := r.LastRun()
+	ranDeployment := run != nil && (before == nil || !run.StartedAt.Equal(before.StartedAt))
+	if err == nil && !ranDeployment {
+		return nil
+	}
+	name := fmt.Sprintf("%s-%s.json", r.Config.CloneDirName, session.StartedAt.Format("20060102T150405.000Z"))
+	path := filepath.Join(r.SessionDir, name)
+	if mkErr := os.MkdirAll(r.SessionDir, 0700); mkErr != nil {
+		r.logger.Warn("Failed to create session directory", "path", r.SessionDir, "error", mkErr)
+	} else if saveErr := session.Save(path); saveErr != nil {
+		r.logger.Warn("Failed to save command session", "error", saveErr)
+	} else {
+		r.logger.Info("Command session saved", "path", path, "commands", len(session.Invocations))
+	}
+	return err
+}
+
+// sessionMeta captures the in-memory and on-disk state process starts from, so a replay can begin
+// from the same place.
+func (r *Repository) sessionMeta() map[string]string {
+	meta := map[string]string{
+		metaRepository:      r.Config.CloneDirName,
+		metaInitialised:     strconv.FormatBool(r.isInitialised),
+		metaLastRemoteState: r.lastRemoteState,
+		metaRedeployFrom:    r.redeployFrom,
+		metaDeployedTag:     r.deployedTag,
+		metaPin:             r.PinnedRef(),
+	}
+	if workDir, err := r.getWorkingPath(); err == nil {
+		meta[metaBasePath] = filepath.Dir(workDir)
+		_, statErr := os.Stat(filepath.Join(workDir, ".git"))
+		meta[metaCloneExists] = strconv.FormatBool(statErr == nil)
+	}
+	if r.Mirror != nil {
+		meta[metaMirrorDir] = filepath.Dir(r.Mirror.Path)
+	}
+	if status := r.Status(); status.State != StateOK {
+		meta[metaState] = string(status.State)
+		meta[metaStateMessage] = status.Message
+	}
+	return meta
+}
+
+// SessionRepository returns the cloneDirName of the repository a session was recorded for.
+func SessionRepository(session *executor.Session) string {
+	return session.Meta[metaRepository]
+}
+
+// SessionRecordedFor reports whether session was recorded for the repository configured by cfg:
+// the same cloneDirName under the same basePath.
+func SessionRecordedFor(session *executor.Session, cfg config.RepositoryConfig) bool {
+	basePath, err := filepath.Abs(cfg.BasePath)
+	return err == nil && cfg.CloneDirName == session.Meta[metaRepository] && basePath == session.Meta[metaBasePath]
+}
+
+// ReplayResult is the outcome of replaying a session.
+type ReplayResult struct {
+	// Err is what Process returned.
+	Err error
+	// Run is the deployment attempt Process made, or nil if it made none.
+	Run *Run
+	// Status is the repository status after Process.
+	Status Status
+	// CheckRetries are the retries Process made outside a deployment stage.
+	CheckRetries []RetryRecord
+	// Executor answered the commands; its Unexpected and Unrun report where the replay diverged
+	// from the recording.
+	Executor *executor.Replayer
+}
+
+// Replay runs Process once for cfg against the commands and results of a recorded session instead of
+// a real executor, starting from the state the recording started from. Nothing is run and the real
+// clone and mirror are left alone: their paths are swapped for a scratch directory, which is removed
+// afterwards. mirrorDir is the configured mirrorDir, if any. Transient failures are retried as retry
+// says, without the delays.
+func Replay(ctx context.Context, cfg config.RepositoryConfig, mirrorDir string, retry executor.RetryPolicy, session *executor.Session, logger *slog.Logger) (*ReplayResult, error) {
+	if cfg.GitBackend == config.GitBackendGo {
+		return nil, fmt.Errorf("gitBackend '%s' runs part of git in-process and cannot be replayed", config.GitBackendGo)
+	}
+	if session.Meta[metaBasePath] == "" {
+		return nil, fmt.Errorf("session does not record the repository's basePath")
+	}
+	if (mirrorDir != "") != (session.Meta[metaMirrorDir] != "") {
+		return nil, fmt.Errorf("mirrorDir must be configured for the replay exactly when the session was recorded with a shared mirror")
+	}
+
+	scratch, err := os.MkdirTemp("", "rivet-replay-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replay directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	scratchBase := filepath.Join(scratch, "base")
+	scratchMirrors := filepath.Join(scratch, "mirrors")
+	rewrites := []string{session.Meta[metaBasePath], scratchBase}
+	if dir := session.Meta[metaMirrorDir]; dir != "" {
+		// The longer path goes first so a mirror directory inside the base path is rewritten as a whole.
+		if len(dir) > len(rewrites[0]) {
+			rewrites = append([]string{dir, scratchMirrors}, rewrites...)
+		} else {
+			rewrites = append(rewrites, dir, scratchMirrors)
+		}
+	}
+	replacer := strings.NewReplacer(rewrites...)
+	replayed := &executor.Session{StartedAt: session.StartedAt, Meta: session.Meta}
+	for _, inv := range session.Invocations {
+		inv.Dir = replacer.Replace(inv.Dir)
+		args := make([]string, len(inv.Args))
+		for i, arg := range inv.Args {
+			args[i] = replacer.Replace(arg)
+		}
+		inv.Args = args
+		replayed.Invocations = append(replayed.Invocations, inv)
+	}
+
+	cfg.BasePath = scratchBase
+	// The health check only waits; the recording already holds what happened after it.
+	cfg.Timeouts.HealthCheck = 0
+	if err := os.MkdirAll(scratchBase, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create replay directory: %w", err)
+	}
+	replayer := executor.NewReplayer(replayed)
+	retry.InitialDelay, retry.MaxDelay = 0, 0
+	exec := executor.NewRetrier(replayer, retry)
+	r := NewRepository(cfg, exec, logger)
+	r.replaying = true
+	if err := r.restoreSessionMeta(session.Meta); err != nil {
+		return nil, err
+	}
+
+	if mirrorDir != "" {
+		r.Mirror = mirror.NewCache(scratchMirrors, exec, logger.WithGroup("mirror")).Get(cfg.GitURL)
+		// Sync clones or fetches depending on whether the mirror exists, and skips both when the
+		// recorded check found it fresh; set the scratch mirror up to take the same branch.
+		cloned, fetched := false, false
+		for _, inv := range replayed.Invocations {
+			cloned = cloned || inv.Dir == filepath.Dir(r.Mirror.Path) && slices.Contains(inv.Args, r.Mirror.Path)
+			fetched = fetched || inv.Dir == r.Mirror.Path
+		}
+		switch {
+		case fetched && !cloned:
+			if err := os.MkdirAll(r.Mirror.Path, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create replay mirror: %w", err)
+			}
+		case !cloned:
+			r.Mirror.MarkSynced(time.Now())
+		}
+	}
+
+	err = r.Process(ctx)
+	return &ReplayResult{Err: err, Run: r.LastRun(), Status: r.Status(), CheckRetries: r.LastCheckRetries(), Executor: replayer}, nil
+}
+
+// restoreSessionMeta puts the repository back into the state recorded by sessionMeta.
+func (r *Repository) restoreSessionMeta(meta map[string]string) error {
+	r.isInitialised = meta[metaInitialised] == "true"
+	r.lastRemoteState = meta[metaLastRemoteState]
+	r.redeployFrom = meta[metaRedeployFrom]
+	r.deployedTag = meta[metaDeployedTag]
+	r.pin = meta[metaPin]
+	if state := meta[metaState]; state != "" {
+		r.status.status = Status{State: State(state), Message: meta[metaStateMessage], Since: time.Now()}
+	}
+	if meta[metaCloneExists] == "true" {
+		workDir, err := r.getWorkingPath()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(workDir, ".git"), 0755); err != nil {
+			return fmt.Errorf("failed to create replay clone: %w", err)
+		}
+	}
+	return nil
+}
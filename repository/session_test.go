@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor"
+)
+
+// git runs git in dir for test setup.
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=rivet", "-c", "user.email=rivet@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestReplayReproducesRecordedProcess(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	// A docker that always succeeds, so the deployment runs to completion.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte("#!/bin/sh\necho \"docker $*\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	remote := t.TempDir()
+	git(t, remote, "init", "-q", "-b", "main")
+	git(t, remote, "commit", "-q", "--allow-empty", "-m", "first")
+
+	recorder := executor.NewRecorder(executor.NewOSCommandExecutor())
+	r := newTestRepository(t, nil, "    timeouts:\n      healthCheck: 1\n")
+	r.Executor = recorder
+	r.Config.GitURL = remote
+	r.isInitialised = false
+
+	// The first check clones and finds nothing to deploy; the second deploys a new commit.
+	ctx := context.Background()
+	if err := r.Process(ctx); err != nil {
+		t.Fatalf("initial check failed: %v", err)
+	}
+	git(t, remote, "commit", "-q", "--allow-empty", "-m", "second")
+	r.SessionDir = t.TempDir()
+	if err := r.Process(ctx); err != nil {
+		t.Fatalf("deployment failed: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(r.SessionDir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected one session file, got %v", files)
+	}
+	session, err := executor.LoadSession(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Replay(ctx, r.Config, "", executor.RetryPolicy{Attempts: 1}, session, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if result.Err != nil || result.Run == nil || !result.Run.Succeeded() {
+		t.Errorf("expected the replay to deploy successfully, got err %v and run %+v", result.Err, result.Run)
+	}
+	if unexpected, unrun := result.Executor.Unexpected(), result.Executor.Unrun(); len(unexpected) > 0 || len(unrun) > 0 {
+		t.Errorf("replay diverged: unexpected %v, unrun %v", unexpected, unrun)
+	}
+}
+
+func TestReplayLeavesOwnershipAlone(t *testing.T) {
+	r := newTestRepository(t, nil, "    runAs:\n      uid: 4242\n      gid: 4242\n")
+	r.replaying = true
+	dir := t.TempDir()
+	if err := r.ensureOwnership(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; int(uid) != os.Getuid() {
+		t.Errorf("expected the replay not to chown the clone, got uid %d", uid)
+	}
+}
+
+func TestSessionRecordedFor(t *testing.T) {
+	session := &executor.Session{Meta: map[string]string{metaRepository: "app", metaBasePath: "/srv/a"}}
+	for _, tc := range []struct {
+		basePath, cloneDirName string
+		want                   bool
+	}{
+		{"/srv/a", "app", true},
+		{"/srv/a/", "app", true},
+		{"/srv/b", "app", false},
+		{"/srv/a", "other", false},
+	} {
+		cfg := config.RepositoryConfig{BasePath: tc.basePath, CloneDirName: tc.cloneDirName}
+		if got := SessionRecordedFor(session, cfg); got != tc.want {
+			t.Errorf("%s/%s: got %v, want %v", tc.basePath, tc.cloneDirName, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor"
+)
+
+// errUntrustedSignature marks a commit whose signature is missing or not from a trusted key,
+// as opposed to a failure to run the verification at all.
+var errUntrustedSignature = errors.New("does not carry a valid signature from a trusted key")
+
+// verifyCommit checks that a single commit carries a valid signature from a trusted key.
+// gpg.minTrustLevel=fully makes git reject both unknown SSH principals and untrusted GPG keys.
+func (r *Repository) verifyCommit(ctx context.Context, commit string) error {
+	workDir, _ := r.getWorkingPath()
+	sig := r.Config.VerifySignatures
+
+	gitArgs := []string{"-c", "gpg.minTrustLevel=fully"}
+	if sig.AllowedSignersFile != "" {
+		gitArgs = append(gitArgs, "-c", "gpg.ssh.allowedSignersFile="+sig.AllowedSignersFile)
+	}
+	gitArgs = append(gitArgs, "verify-commit", commit)
+
+	cmd := executor.Command{Name: "git", Args: gitArgs, Dir: workDir, Env: r.userEnv(), Credential: r.credential()}
+	if sig.GPGHome != "" {
+		cmd.Env = append(cmd.Env, "GNUPGHOME="+sig.GPGHome)
+	}
+
+	if _, err := r.Executor.Execute(ctx, cmd); err != nil {
+		var exitErr *executor.ExitError
+		if !errors.As(err, &exitErr) {
+			return fmt.Errorf("failed to verify commit %s: %w", commit, err)
+		}
+		r.logger.Debug("Signature verification failed", "commit", commit, "exitCode", exitErr.Result.ExitCode, "stderr", exitErr.Result.Stderr)
+		return fmt.Errorf("commit %s %w", commit, errUntrustedSignature)
+	}
+	return nil
+}
+
+// verifySignatures checks the signatures required by the verifySignatures configuration for an
+// update from localCommit to targetCommit. It returns false, and marks the repository as refused,
+// when a required signature is missing or untrusted. Otherwise it clears any earlier refusal.
+// An empty localCommit limits the check to targetCommit.
+func (r *Repository) verifySignatures(ctx context.Context, localCommit, targetCommit string) (bool, error) {
+	if r.Config.VerifySignatures == nil {
+		r.status.clear(r.logger, StateRefused)
+		return true, nil
+	}
+
+	commits := []string{targetCommit}
+	if r.Config.VerifySignatures.Scope == config.SignatureScopeAll && localCommit != "" {
+		revList, err := r.gitOutput(ctx, "rev-list", localCommit+".."+targetCommit)
+		if err != nil {
+			return false, err
+		}
+		commits = splitLines(revList)
+	}
+
+	for _, commit := range commits {
+		if err := r.verifyCommit(ctx, commit); err != nil {
+			if !errors.Is(err, errUntrustedSignature) {
+				return false, err
+			}
+			r.status.set(r.logger, StateRefused, fmt.Sprintf("refusing to deploy %s: %v", targetCommit, err))
+			return false, nil
+		}
+	}
+	r.logger.Debug("Signatures verified", "commits", len(commits), "targetCommit", targetCommit)
+	r.status.clear(r.logger, StateRefused)
+	return true, nil
+}
+
+// verifyRedeploy checks the signatures for deploying targetCommit while the checkout left behind by
+// a reconciled clone or a failed deployment is pending, starting from the commit deployed before. A
+// recloned checkout may no longer have that commit, and then only targetCommit can be checked, even
+// with scope all.
+func (r *Repository) verifyRedeploy(ctx context.Context, targetCommit string) (bool, error) {
+	from := r.redeployFrom
+	if sig := r.Config.VerifySignatures; sig != nil && sig.Scope == config.SignatureScopeAll {
+		present, err := r.hasCommit(ctx, from)
+		if err != nil {
+			return false, err
+		}
+		if !present {
+			r.logger.Warn("Previously deployed commit is not in the clone, verifying only the target commit", "previousCommit", from, "commit", targetCommit)
+			from = ""
+		}
+	}
+	return r.verifySignatures(ctx, from, targetCommit)
+}
+
+// verifyUpdate checks the signatures for moving the checkout at localCommit to targetCommit. While
+// the checkout is pending redeployment, the commits since the last deployed one are checked too.
+func (r *Repository) verifyUpdate(ctx context.Context, localCommit, targetCommit string) (bool, error) {
+	if r.redeployFrom != "" {
+		return r.verifyRedeploy(ctx, targetCommit)
+	}
+	return r.verifySignatures(ctx, localCommit, targetCommit)
+}
+
+// hasCommit reports whether commit is in the clone. A recloned checkout may have lost commits that
+// were deployed before.
+func (r *Repository) hasCommit(ctx context.Context, commit string) (bool, error) {
+	workDir, _ := r.getWorkingPath()
+	if _, err := r.execGit(ctx, workDir, "cat-file", "-e", commit+"^{commit}"); err != nil {
+		var exitErr *executor.ExitError
+		if !errors.As(err, &exitErr) {
+			return false, fmt.Errorf("git cat-file failed: %w", err)
+		}
+		return false, nil
+	}
+	return true, nil
+}
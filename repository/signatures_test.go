@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+func TestRedeployAfterReconcileVerifiesSignatures(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		scope      string
+		oldPresent bool
+		signed     bool
+		deploy     bool
+	}{
+		{"unsigned head is refused", "head", true, false, false},
+		{"signed head is redeployed", "head", true, true, true},
+		{"scope all checks commits since the deployed one", "all", true, true, true},
+		{"scope all falls back to the head after a reclone", "all", false, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := executortest.New()
+			r := newTestRepository(t, fake, `
+    verifySignatures:
+      scope: `+tc.scope+`
+      allowedSignersFile: /etc/rivet/allowed_signers
+`)
+			r.redeployFrom = "old"
+			fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("new\n")
+			fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("new\n")
+			verified := []string{"new"}
+			if tc.scope == "all" {
+				catFile := fake.Expect("git", "cat-file", "-e", "old^{commit}")
+				if tc.oldPresent {
+					fake.Expect("git", "rev-list", "old..new").Returns("new\nmid\n")
+					verified = append(verified, "mid")
+				} else {
+					catFile.ExitCode(128)
+				}
+			}
+			for _, commit := range verified {
+				verify := fake.Expect("git", "-c", "gpg.minTrustLevel=fully", "-c", "gpg.ssh.allowedSignersFile=/etc/rivet/allowed_signers", "verify-commit", commit)
+				if !tc.signed {
+					verify.ExitCode(1)
+				}
+			}
+
+			deploy, err := r.checkForBranchUpdates(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fake.Verify(t)
+			if deploy != tc.deploy {
+				t.Errorf("deploy = %v, want %v", deploy, tc.deploy)
+			}
+			if tc.deploy && (r.fromCommit != "old" || r.toCommit != "new" || r.redeployFrom != "") {
+				t.Errorf("unexpected redeploy from %q to %q, pending %q", r.fromCommit, r.toCommit, r.redeployFrom)
+			}
+			if !tc.deploy && (r.Status().State != StateRefused || r.redeployFrom != "old") {
+				t.Errorf("expected a refusal that keeps the redeploy pending, got %+v, pending %q", r.Status(), r.redeployFrom)
+			}
+		})
+	}
+}
+
+func TestPinVerifiesSignature(t *testing.T) {
+	for _, signed := range []bool{false, true} {
+		fake := executortest.New()
+		r := newTestRepository(t, fake, "    verifySignatures:\n      allowedSignersFile: /etc/rivet/allowed_signers\n")
+		r.Pin("v1.0")
+		fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--tags", "--prune", "--prune-tags", "--force")
+		fake.Expect("git", "rev-parse", "--verify", "--quiet", "v1.0^{commit}").Returns("p\n")
+		fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+		fake.Expect("git", "rev-list", "--count", "p..origin/main").Returns("3\n")
+		verify := fake.Expect("git", "-c", "gpg.minTrustLevel=fully", "-c", "gpg.ssh.allowedSignersFile=/etc/rivet/allowed_signers", "verify-commit", "p")
+		if !signed {
+			verify.ExitCode(1)
+		}
+
+		updates, err := r.CheckForUpdates(context.Background())
+		if err != nil {
+			t.Fatalf("signed %v: unexpected error: %v", signed, err)
+		}
+		fake.Verify(t)
+		if signed && (!updates || r.checkoutTarget != "p") {
+			t.Errorf("expected the signed pin to be checked out, got updates %v, target %q", updates, r.checkoutTarget)
+		}
+		if !signed && (updates || r.checkoutTarget != "" || r.Status().State != StateRefused) {
+			t.Errorf("expected the unsigned pin to be refused, got updates %v, target %q, status %+v", updates, r.checkoutTarget, r.Status())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// State is the operational state of a repository as seen by rivet.
+type State string
+
+const (
+	// StateOK means the repository is being followed normally.
+	StateOK State = "ok"
+	// StateDiverged means the local checkout is not an ancestor of the remote and
+	// the divergence policy refused to move it.
+	StateDiverged State = "diverged"
+	// StateDirty means the clone has local modifications and the dirty policy blocks
+	// pulling until an operator cleans it up.
+	StateDirty State = "dirty"
+	// StateConfigMismatch means the existing clone tracks a different remote or branch than
+	// configured and the mismatch policy refused to change it.
+	StateConfigMismatch State = "config-mismatch"
+	// StateRefused means rivet found a newer target but refused to deploy it, e.g. because
+	// selecting it would downgrade the deployed release.
+	StateRefused State = "refused"
+	// StatePinned means the repository is held at a pinned ref and not following its remote.
+	StatePinned State = "pinned"
+)
+
+// Status describes the current state of a repository and why it is in that state.
+type Status struct {
+	State   State
+	Message string
+	Since   time.Time
+}
+
+// statusTracker holds the repository status and reports transitions through the logger,
+// so a condition is announced once when it starts and once when it clears instead of on every tick.
+type statusTracker struct {
+	mu     sync.Mutex
+	status Status
+}
+
+func (st *statusTracker) get() Status {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.status.State == "" {
+		return Status{State: StateOK}
+	}
+	return st.status
+}
+
+func (st *statusTracker) set(logger *slog.Logger, state State, message string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	previous := st.status.State
+	if previous == "" {
+		previous = StateOK
+	}
+	if previous == state && st.status.Message == message {
+		return
+	}
+	st.status = Status{State: state, Message: message, Since: time.Now()}
+	if state == StateOK {
+		logger.Info("Repository status recovered", "previousState", previous)
+		return
+	}
+	if state == StatePinned {
+		logger.Info("Repository is pinned", "reason", message)
+		return
+	}
+	logger.Error("Repository needs attention", "state", state, "reason", message)
+}
+
+// clear returns the repository to StateOK if it is currently in the given state.
+func (st *statusTracker) clear(logger *slog.Logger, state State) {
+	if st.get().State == state {
+		st.set(logger, StateOK, "")
+	}
+}
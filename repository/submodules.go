@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// UpdateSubmodules recursively initialises and updates submodules to the commits recorded in
+// the checkout. It is a no-op unless submodules are enabled for the repository.
+func (r *Repository) UpdateSubmodules(ctx context.Context) error {
+	if !r.Config.Submodules {
+		return nil
+	}
+	r.logger.Info("Updating submodules...")
+	// sync first so a changed submodule URL in .gitmodules is picked up before updating.
+	if err := r.runGitStep(ctx, "submodule", "sync", "--recursive"); err != nil {
+		return fmt.Errorf("submodule sync failed: %w", err)
+	}
+	if err := r.runGitStep(ctx, "submodule", "update", "--init", "--recursive", "--force"); err != nil {
+		return fmt.Errorf("submodule update failed: %w", err)
+	}
+	r.logger.Info("Submodules updated.")
+	return nil
+}
+
+// PullLFS downloads the Git LFS objects for the checkout, replacing pointer files with their
+// content. It is a no-op unless LFS is enabled for the repository.
+func (r *Repository) PullLFS(ctx context.Context) error {
+	if !r.Config.LFS {
+		return nil
+	}
+	r.logger.Info("Pulling LFS objects...")
+	if err := r.runGitStep(ctx, "lfs", "pull"); err != nil {
+		return fmt.Errorf("git lfs pull failed: %w", err)
+	}
+	if r.Config.Submodules {
+		if err := r.runGitStep(ctx, "submodule", "foreach", "--recursive", "git lfs pull"); err != nil {
+			return fmt.Errorf("git lfs pull in submodules failed: %w", err)
+		}
+	}
+	r.logger.Info("LFS objects pulled.")
+	return nil
+}
+
+// advanceCheckout moves the checkout to an update that is not deployed, e.g. commits that all carry
+// a skip directive, and brings its submodules and LFS objects along as a deployment would.
+func (r *Repository) advanceCheckout(ctx context.Context) error {
+	if err := r.PullChanges(ctx); err != nil {
+		return err
+	}
+	if err := r.UpdateSubmodules(ctx); err != nil {
+		return err
+	}
+	return r.PullLFS(ctx)
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+const submodulesAndLFSYAML = "    submodules: true\n    lfs: true\n"
+
+// expectSubmodulesAndLFS scripts the submodule update and LFS pull, failing the command named by failing.
+func expectSubmodulesAndLFS(fake *executortest.Fake, failing string) {
+	for _, args := range [][]string{
+		{"submodule", "sync", "--recursive"},
+		{"submodule", "update", "--init", "--recursive", "--force"},
+		{"lfs", "pull"},
+		{"submodule", "foreach", "--recursive", "git lfs pull"},
+	} {
+		e := fake.Expect("git", args...)
+		if strings.Join(args, " ") == failing {
+			e.Stderr("fatal: repository not found\n").ExitCode(128)
+			return
+		}
+	}
+}
+
+func TestSubmodulesAndLFSAfterClone(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, submodulesAndLFSYAML)
+	r.isInitialised = false
+	fake.Expect("git", "clone", "-b", "main", "https://example.com/app.git", "app")
+	expectSubmodulesAndLFS(fake, "")
+
+	if err := r.ensureCloned(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake.Verify(t)
+	var commands []string
+	for _, call := range fake.Calls() {
+		commands = append(commands, call.String())
+	}
+	want := []string{
+		"git clone -b main https://example.com/app.git app",
+		"git submodule sync --recursive",
+		"git submodule update --init --recursive --force",
+		"git lfs pull",
+		"git submodule foreach --recursive git lfs pull",
+	}
+	if !slices.Equal(commands, want) {
+		t.Errorf("expected submodules and LFS objects to follow the clone, got %q", commands)
+	}
+}
+
+func TestSubmoduleFailureFailsClone(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, submodulesAndLFSYAML)
+	r.isInitialised = false
+	fake.Expect("git", "clone", "-b", "main", "https://example.com/app.git", "app")
+	expectSubmodulesAndLFS(fake, "submodule update --init --recursive --force")
+
+	err := r.ensureCloned(context.Background())
+	fake.Verify(t)
+	if err == nil || !strings.Contains(err.Error(), "submodule update failed") || r.isInitialised {
+		t.Errorf("expected the clone to fail on the submodule update, got %v", err)
+	}
+}
+
+func TestLFSFailureFailsDeploymentStage(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, submodulesAndLFSYAML)
+	expectPull(fake)
+	expectSubmodulesAndLFS(fake, "lfs pull")
+
+	// The build must not start from pointer files.
+	r.runs.start("a", "b")
+	err := r.deploy(context.Background())
+	r.runs.finish(err)
+	fake.Verify(t)
+	if err == nil || !strings.Contains(err.Error(), "LFS pull failed") {
+		t.Fatalf("expected the deployment to fail in the LFS stage, got %v", err)
+	}
+	var stages []string
+	for _, stage := range r.LastRun().Stages {
+		stages = append(stages, string(stage.Status)+" "+stage.Name)
+	}
+	want := []string{"succeeded pull", "succeeded submodules", "failed lfs"}
+	if !slices.Equal(stages, want) {
+		t.Errorf("expected stages %q, got %q", want, stages)
+	}
+}
+
+func TestSkippedUpdateUpdatesSubmodulesAndLFS(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, submodulesAndLFSYAML)
+	fake.Expect("git", "ls-remote", "origin", "refs/heads/main").Returns("b\trefs/heads/main\n")
+	fake.Expect("git", "fetch", "origin", r.branchRefSpec(), "--prune")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("a\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "origin/main").Returns("b\n")
+	fake.Expect("git", "merge-base", "--is-ancestor", "a", "b")
+	fake.Expect("git", "log", "--format=%B%x00", "a..b").Returns("[skip deploy] docs\x00")
+	expectPull(fake)
+	expectSubmodulesAndLFS(fake, "")
+
+	// The skipped commits are not deployed, but the checkout must still match them in full.
+	updates, err := r.CheckForUpdates(context.Background())
+	fake.Verify(t)
+	if err != nil || updates {
+		t.Fatalf("expected the skipped commits to be passed over, got %v, %v", updates, err)
+	}
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/tmunongo/rivet/gitclient"
+)
+
+// matchesTagFilter reports whether a tag is selected by the configured tagPattern or tagRegex.
+func (r *Repository) matchesTagFilter(tag string) bool {
+	if r.Config.TagRegex != "" {
+		matched, err := regexp.MatchString(r.Config.TagRegex, tag)
+		return err == nil && matched
+	}
+	matched, err := path.Match(r.Config.TagPattern, tag)
+	return err == nil && matched
+}
+
+// highestTag returns the highest of the tags that match the tag filter, or "" if none match.
+func (r *Repository) highestTag(tags []string) string {
+	best := ""
+	for _, tag := range tags {
+		if !r.matchesTagFilter(tag) {
+			continue
+		}
+		if best == "" || compareTags(tag, best) > 0 {
+			best = tag
+		}
+	}
+	return best
+}
+
+// isDowngrade reports whether moving from the deployed tag to the candidate tag lowers the
+// semantic version. Tags that are not semantic versions are never considered downgrades.
+func isDowngrade(deployed, candidate string) bool {
+	deployedVersion, ok := parseSemver(deployed)
+	if !ok {
+		return false
+	}
+	candidateVersion, ok := parseSemver(candidate)
+	if !ok {
+		return false
+	}
+	return candidateVersion.compare(deployedVersion) < 0
+}
+
+func splitLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// checkForTagUpdates fetches tags and reports whether the highest matching tag points at a
+// different commit than the checkout. PullChanges then checks that commit out.
+//
+// Downgrades are judged against the deployed tag, which is remembered rather than looked up on
+// HEAD: the fetch prunes tags deleted on the remote, so a retracted release would no longer be
+// found on the checkout. Until a release has been deployed, it is read from HEAD before fetching.
+func (r *Repository) checkForTagUpdates(ctx context.Context) (bool, error) {
+	workDir, _ := r.getWorkingPath()
+	if r.deployedTag == "" {
+		headTags, err := r.gitOutput(ctx, "tag", "--list", "--points-at", "HEAD")
+		if err != nil {
+			return false, err
+		}
+		r.deployedTag = r.highestTag(splitLines(headTags))
+	}
+
+	r.logger.Debug("Running 'git fetch' for tags...")
+	fetchOpts := gitclient.FetchOptions{Remote: r.fetchRemote(), Tags: true, Prune: true, Force: true, UploadPack: r.uploadPack()}
+	if err := r.Git.Fetch(ctx, workDir, fetchOpts); err != nil {
+		r.logger.Error("Git fetch failed", "error", err)
+		return false, err
+	}
+
+	tagList, err := r.gitOutput(ctx, "tag", "--list")
+	if err != nil {
+		return false, err
+	}
+	targetTag := r.highestTag(splitLines(tagList))
+	if targetTag == "" {
+		r.logger.Warn("No tags match the configured filter. Nothing to deploy.", "tagPattern", r.Config.TagPattern, "tagRegex", r.Config.TagRegex)
+		return false, nil
+	}
+
+	targetCommit, err := r.Git.RevParse(ctx, workDir, targetTag+"^{commit}")
+	if err != nil {
+		return false, err
+	}
+	localCommit, err := r.Git.RevParse(ctx, workDir, "HEAD")
+	if err != nil {
+		return false, err
+	}
+	r.logger.Debug("Resolved release tag", "tag", targetTag, "sha", targetCommit, "localCommit", localCommit)
+
+	if localCommit == targetCommit {
+		r.status.clear(r.logger, StateRefused)
+		if r.redeployFrom != "" {
+			r.toTag = targetTag
+			return r.redeployCheckout(ctx, localCommit)
+		}
+		r.deployedTag = targetTag
+		r.logger.Info("No updates found. Checkout is at the highest matching tag.", "tag", targetTag, "commit", localCommit)
+		return false, nil
+	}
+
+	if r.deployedTag != "" && isDowngrade(r.deployedTag, targetTag) {
+		if !r.Config.AllowDowngrade {
+			r.status.set(r.logger, StateRefused, fmt.Sprintf("highest matching tag %s is older than deployed tag %s; set allowDowngrade to deploy it", targetTag, r.deployedTag))
+			return false, nil
+		}
+		r.logger.Warn("Downgrading release as allowed by configuration", "from", r.deployedTag, "to", targetTag)
+	}
+
+	verified, err := r.verifyUpdate(ctx, localCommit, targetCommit)
+	if err != nil || !verified {
+		return false, err
+	}
+
+	r.checkoutTarget = targetCommit
+	from := r.updateStart(localCommit)
+	deploy, err := r.shouldDeploy(ctx, from, targetCommit)
+	if err != nil {
+		return false, err
+	}
+	if !deploy {
+		if err := r.advanceCheckout(ctx); err != nil {
+			return false, fmt.Errorf("failed to check out skipped tag: %w", err)
+		}
+		r.deployedTag = targetTag
+		r.redeployFrom = ""
+		return false, nil
+	}
+
+	r.logger.Info("New release tag found!", "tag", targetTag, "localCommit", localCommit, "targetCommit", targetCommit, "deployedCommit", from)
+	r.fromCommit, r.toCommit = from, targetCommit
+	r.toTag = targetTag
+	r.redeployFrom = ""
+	return true, nil
+}
+
+// checkoutDetached moves the checkout to the given commit without touching any branch.
+func (r *Repository) checkoutDetached(ctx context.Context, commit string) error {
+	r.logger.Info("Checking out commit...", "commit", commit)
+	workDir, _ := r.getWorkingPath()
+	if err := r.Git.Checkout(ctx, workDir, commit); err != nil {
+		r.logger.Error("Git checkout failed", "commit", commit, "error", err)
+		return fmt.Errorf("git checkout failed: %w", err)
+	}
+	r.logger.Info("'git checkout' successful.", "commit", commit)
+	return nil
+}
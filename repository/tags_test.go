@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+func TestDowngradeRefusedAfterDeployedTagIsPruned(t *testing.T) {
+	fake := executortest.New()
+	r := newTestRepository(t, fake, "    track: tag\n")
+	// v2.0.0 is deployed at b and has since been deleted on the remote, so the fetch prunes it and
+	// only v1.0.0 at a is left to select.
+	fake.Expect("git", "ls-remote", "--tags", "origin").Returns("a\trefs/tags/v1.0.0\n")
+	fake.Expect("git", "tag", "--list", "--points-at", "HEAD").Returns("v2.0.0\n")
+	fake.Expect("git", "fetch", "origin", "--tags", "--prune", "--prune-tags", "--force")
+	fake.Expect("git", "tag", "--list").Returns("v1.0.0\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "v1.0.0^{commit}").Returns("a\n")
+	fake.Expect("git", "rev-parse", "--verify", "--quiet", "HEAD").Returns("b\n")
+
+	updates, err := r.CheckForUpdates(context.Background())
+	if err != nil || updates {
+		t.Fatalf("updates = %v, err = %v", updates, err)
+	}
+	fake.Verify(t)
+	if status := r.Status(); status.State != StateRefused {
+		t.Errorf("expected the downgrade to v1.0.0 to be refused, got %+v", status)
+	}
+}
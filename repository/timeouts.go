@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// withTimeout derives a context bounded by a timeout in seconds; zero leaves ctx unbounded.
+func withTimeout(ctx context.Context, timeoutSeconds int) (context.Context, context.CancelFunc) {
+	if timeoutSeconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, seconds(timeoutSeconds))
+}
+
+// timedOut reports whether err was caused by a deadline derived from parent rather than by parent
+// itself ending, so a shutdown is never reported as a timeout.
+func timedOut(parent context.Context, err error) bool {
+	return err != nil && errors.Is(err, context.DeadlineExceeded) && parent.Err() == nil
+}
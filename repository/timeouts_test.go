@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimedOut(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	stageCtx, cancel := withTimeout(parent, 1)
+	defer cancel()
+
+	// A stage deadline that passed counts as a timeout.
+	expired, cancelExpired := context.WithDeadline(parent, time.Now())
+	defer cancelExpired()
+	<-expired.Done()
+	if err := fmt.Errorf("git fetch failed: %w", expired.Err()); !timedOut(parent, err) {
+		t.Error("expected an expired stage deadline to count as a timeout")
+	}
+
+	if timedOut(parent, nil) || timedOut(parent, fmt.Errorf("exit 1")) {
+		t.Error("expected ordinary results not to count as timeouts")
+	}
+
+	// Once the parent itself has ended, the failure is a shutdown rather than a timeout.
+	cancelParent()
+	<-stageCtx.Done()
+	if timedOut(parent, context.DeadlineExceeded) {
+		t.Error("expected a cancelled parent not to be reported as a timeout")
+	}
+}
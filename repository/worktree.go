@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tmunongo/rivet/config"
+)
+
+// ErrWorkingTreeDirty is returned when the clone has local modifications and the
+// configured dirty policy does not allow rivet to remove them.
+var ErrWorkingTreeDirty = errors.New("working tree has local modifications")
+
+// localModifications lists uncommitted changes and untracked files in porcelain format.
+func (r *Repository) localModifications(ctx context.Context) ([]string, error) {
+	workDir, _ := r.getWorkingPath()
+	result, err := r.execGit(ctx, workDir, "status", "--porcelain", "--untracked-files=all")
+	if err != nil {
+		r.logger.Error("Git status failed", "error", err)
+		return nil, fmt.Errorf("git status failed: %w", err)
+	}
+
+	var changes []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if strings.TrimSpace(line) != "" {
+			changes = append(changes, line)
+		}
+	}
+	return changes, nil
+}
+
+// ensureCleanWorkingTree checks the clone for local modifications and applies the configured
+// dirty policy. It returns an error wrapping ErrWorkingTreeDirty when the repository is blocked.
+func (r *Repository) ensureCleanWorkingTree(ctx context.Context) error {
+	changes, err := r.localModifications(ctx)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		r.status.clear(r.logger, StateDirty)
+		return nil
+	}
+
+	switch r.Config.OnDirty {
+	case config.DirtyStash:
+		r.logger.Warn("Working tree has local modifications. Stashing them.", "changes", changes)
+		message := fmt.Sprintf("rivet auto-stash %s", time.Now().UTC().Format(time.RFC3339))
+		if err := r.runGitStep(ctx, "stash", "push", "--include-untracked", "-m", message); err != nil {
+			return err
+		}
+		r.logger.Warn("Local modifications stashed. Recover them with 'git stash list' in the clone directory.", "stash", message)
+	case config.DirtyDiscard:
+		r.logger.Warn("Working tree has local modifications. Discarding them.", "changes", changes)
+		if err := r.runGitStep(ctx, "reset", "--hard", "HEAD"); err != nil {
+			return err
+		}
+		if err := r.runGitStep(ctx, "clean", "-fd"); err != nil {
+			return err
+		}
+	default:
+		r.status.set(r.logger, StateDirty, fmt.Sprintf("%d local modification(s) in the clone (first: %s); commit, stash or remove them to resume deployments", len(changes), strings.TrimSpace(changes[0])))
+		return fmt.Errorf("%w: %d change(s)", ErrWorkingTreeDirty, len(changes))
+	}
+
+	r.status.clear(r.logger, StateDirty)
+	return nil
+}
+
+// runGitStep runs a git command in the clone directory whose output is only needed for logging.
+func (r *Repository) runGitStep(ctx context.Context, args ...string) error {
+	workDir, _ := r.getWorkingPath()
+	if _, err := r.execGit(ctx, workDir, args...); err != nil {
+		r.logger.Error("Git command failed", "command", args[0], "error", err)
+		return fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return nil
+}
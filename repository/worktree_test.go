@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tmunongo/rivet/config"
+	"github.com/tmunongo/rivet/executor/executortest"
+)
+
+func TestDirtyPolicies(t *testing.T) {
+	cases := []struct {
+		policy    string
+		cleanup   func(fake *executortest.Fake) // scripts how the modifications are cleared
+		wantErr   error
+		wantState State
+	}{
+		{config.DirtyBlock, func(*executortest.Fake) {}, ErrWorkingTreeDirty, StateDirty},
+		{config.DirtyStash, func(fake *executortest.Fake) {
+			// The stash message carries the current second, which may tick over during the test.
+			now := time.Now().UTC()
+			for _, at := range []time.Time{now, now.Add(time.Second)} {
+				fake.Expect("git", "stash", "push", "--include-untracked", "-m", "rivet auto-stash "+at.Format(time.RFC3339)).AnyTimes()
+			}
+		}, nil, StateOK},
+		{config.DirtyDiscard, func(fake *executortest.Fake) {
+			fake.Expect("git", "reset", "--hard", "HEAD")
+			fake.Expect("git", "clean", "-fd")
+		}, nil, StateOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			fake := executortest.New()
+			r := newTestRepository(t, fake, "    onDirty: "+tc.policy+"\n")
+			fake.Expect("git", "status", "--porcelain", "--untracked-files=all").Returns(" M app.go\n?? notes.txt\n")
+			tc.cleanup(fake)
+
+			err := r.ensureCleanWorkingTree(context.Background())
+			fake.Verify(t)
+			if !errors.Is(err, tc.wantErr) || (tc.wantErr == nil && err != nil) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if state := r.Status().State; state != tc.wantState {
+				t.Errorf("expected state %s, got %+v", tc.wantState, r.Status())
+			}
+			stashes := 0
+			for _, call := range fake.Calls() {
+				if call.Args[0] == "stash" {
+					stashes++
+				}
+			}
+			wantStashes := 0
+			if tc.policy == config.DirtyStash {
+				wantStashes = 1
+			}
+			if stashes != wantStashes {
+				t.Errorf("expected %d stash(es), got %d", wantStashes, stashes)
+			}
+		})
+	}
+}
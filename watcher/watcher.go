@@ -9,6 +9,7 @@ import (
 
 	"github.com/tmunongo/rivet/config"
 	"github.com/tmunongo/rivet/executor"
+	"github.com/tmunongo/rivet/mirror"
 	"github.com/tmunongo/rivet/repository"
 )
 
@@ -28,10 +29,20 @@ func NewWatcher(appCfg *config.AppConfig, exec executor.CommandExecutor, logger
 		logger:    logger,
 	}
 
+	var mirrors *mirror.Cache
+	if appCfg.MirrorDir != "" {
+		mirrors = mirror.NewCache(appCfg.MirrorDir, exec, logger.WithGroup("mirror"))
+	}
+
 	for _, repoCfg := range appCfg.Repositories {
 		// Create a child logger for each repository for contextual logging
 		repoLogger := logger.With("repositoryPath", filepath.Join(repoCfg.BasePath, repoCfg.CloneDirName), "branch", repoCfg.Branch)
-		w.repos = append(w.repos, repository.NewRepository(repoCfg, exec, repoLogger))
+		repo := repository.NewRepository(repoCfg, exec, repoLogger)
+		repo.SessionDir = appCfg.SessionDir
+		if mirrors != nil {
+			repo.Mirror = mirrors.Get(repoCfg.GitURL)
+		}
+		w.repos = append(w.repos, repo)
 	}
 	return w
 }